@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/utilitywarehouse/manifest-checkers/kustomizebuild"
+	"gopkg.in/yaml.v2"
+)
+
+// object represents the identifying fields of a Kubernetes object
+type object struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+}
+
+// objectID uniquely identifies an object within a cluster
+type objectID struct {
+	group     string
+	kind      string
+	namespace string
+	name      string
+}
+
+func (id objectID) String() string {
+	kind := id.kind
+	if id.group != "" {
+		kind = id.group + "/" + id.kind
+	}
+	if id.namespace == "" {
+		return fmt.Sprintf("%s %s", kind, id.name)
+	}
+	return fmt.Sprintf("%s %s/%s", kind, id.namespace, id.name)
+}
+
+// findDuplicateObjects groups kustomization roots by the cluster they target,
+// taken to be their first clusterDepth directories, and returns an error
+// listing every object produced by more than one root in the same cluster,
+// since these would overwrite each other when applied
+func findDuplicateObjects(manifestMap map[string]string, clusterDepth int) error {
+	// cluster -> object -> roots producing that object
+	clusters := map[string]map[objectID][]string{}
+	for root, manifest := range manifestMap {
+		ids, err := parseObjectIDs(manifest)
+		if err != nil {
+			return fmt.Errorf("error parsing manifests built from %s: %v", root, err)
+		}
+
+		cluster := clusterKey(root, clusterDepth)
+		if clusters[cluster] == nil {
+			clusters[cluster] = map[objectID][]string{}
+		}
+		for _, id := range ids {
+			clusters[cluster][id] = append(clusters[cluster][id], root)
+		}
+	}
+
+	var duplicates []string
+	for cluster, objects := range clusters {
+		for id, roots := range objects {
+			if len(roots) < 2 {
+				continue
+			}
+			sort.Strings(roots)
+			duplicates = append(
+				duplicates,
+				fmt.Sprintf("%s in cluster %s built from: %s", id, cluster, strings.Join(roots, ", ")),
+			)
+		}
+	}
+
+	if len(duplicates) != 0 {
+		// sort for a consistent output
+		sort.Strings(duplicates)
+		return fmt.Errorf(
+			"found objects built from multiple kustomizations:\n\t%s",
+			strings.Join(duplicates, "\n\t"),
+		)
+	}
+	return nil
+}
+
+// clusterKey returns the first depth directories of a kustomization root, or
+// the whole root if it isn't that deep
+func clusterKey(root string, depth int) string {
+	segments := strings.Split(filepath.ToSlash(root), "/")
+	if len(segments) > depth {
+		segments = segments[:depth]
+	}
+	return strings.Join(segments, "/")
+}
+
+// parseObjectIDs returns the identity of every object in a multi-document
+// manifest
+func parseObjectIDs(manifest string) ([]objectID, error) {
+	var ids []objectID
+	for _, document := range kustomizebuild.SplitDocuments(manifest) {
+		var obj object
+		if err := yaml.Unmarshal([]byte(document), &obj); err != nil {
+			return nil, err
+		}
+		if obj.Kind == "" {
+			continue
+		}
+
+		group := ""
+		if i := strings.LastIndex(obj.APIVersion, "/"); i != -1 {
+			group = obj.APIVersion[:i]
+		}
+		ids = append(ids, objectID{
+			group:     group,
+			kind:      obj.Kind,
+			namespace: obj.Metadata.Namespace,
+			name:      obj.Metadata.Name,
+		})
+	}
+	return ids, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	deploymentInNamespaceTemplate = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: %s
+  namespace: %s
+`
+	clusterRole = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: reader
+`
+)
+
+func TestFindDuplicateObjects(t *testing.T) {
+	tests := []struct {
+		name          string
+		manifestMap   map[string]string
+		clusterDepth  int
+		expectedError string
+	}{
+		{
+			name: "distinct objects",
+			manifestMap: map[string]string{
+				"cluster-a/ns-a": fmt.Sprintf(deploymentInNamespaceTemplate, "app", "ns-a"),
+				"cluster-a/ns-b": fmt.Sprintf(deploymentInNamespaceTemplate, "app", "ns-b"),
+			},
+			clusterDepth: 1,
+		},
+		{
+			name: "same object in different clusters",
+			manifestMap: map[string]string{
+				"cluster-a/ns-a": fmt.Sprintf(deploymentInNamespaceTemplate, "app", "ns-a"),
+				"cluster-b/ns-a": fmt.Sprintf(deploymentInNamespaceTemplate, "app", "ns-a"),
+			},
+			clusterDepth: 1,
+		},
+		{
+			name: "same object in the same cluster",
+			manifestMap: map[string]string{
+				"cluster-a/ns-a":     fmt.Sprintf(deploymentInNamespaceTemplate, "app", "ns-a"),
+				"cluster-a/ns-a-dup": fmt.Sprintf(deploymentInNamespaceTemplate, "app", "ns-a") + "---\n" + clusterRole,
+				"cluster-a/rbac":     clusterRole,
+			},
+			clusterDepth: 1,
+			expectedError: "found objects built from multiple kustomizations:\n" +
+				"\tapps/Deployment ns-a/app in cluster cluster-a built from: cluster-a/ns-a, cluster-a/ns-a-dup\n" +
+				"\trbac.authorization.k8s.io/ClusterRole reader in cluster cluster-a built from: cluster-a/ns-a-dup, cluster-a/rbac",
+		},
+		{
+			name: "same object with deeper cluster key",
+			manifestMap: map[string]string{
+				"aws/cluster-a/ns-a": fmt.Sprintf(deploymentInNamespaceTemplate, "app", "ns-a"),
+				"aws/cluster-b/ns-a": fmt.Sprintf(deploymentInNamespaceTemplate, "app", "ns-a"),
+			},
+			clusterDepth: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := findDuplicateObjects(tt.manifestMap, tt.clusterDepth)
+			if tt.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.expectedError)
+			}
+		})
+	}
+}
+
+func TestFindDuplicateObjectsFailsOnUnparseableManifest(t *testing.T) {
+	manifestMap := map[string]string{
+		"cluster-a/ns-a": "kind: [Deployment\n",
+	}
+
+	err := findDuplicateObjects(manifestMap, 1)
+
+	requireErorrPrefix(t, err, "error parsing manifests built from cluster-a/ns-a:")
+}
+
+func TestClusterKey(t *testing.T) {
+	require.Equal(t, "cluster-a", clusterKey("cluster-a/ns-a/app", 1))
+	require.Equal(t, "cluster-a/ns-a", clusterKey("cluster-a/ns-a/app", 2))
+	require.Equal(t, "cluster-a", clusterKey("cluster-a", 2))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	imagesFormatText = "text"
+	imagesFormatJSON = "json"
+)
+
+// imageRef is the image of a container in a built object
+type imageRef struct {
+	Root      string `json:"root"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// ContainerType is the pod spec field holding the container, one of
+	// 'containers', 'initContainers' or 'ephemeralContainers'
+	ContainerType string `json:"containerType"`
+	Container     string `json:"container"`
+	Image         string `json:"image"`
+	Repository    string `json:"repository"`
+	Tag           string `json:"tag,omitempty"`
+	Digest        string `json:"digest,omitempty"`
+}
+
+// listImages builds the kustomizations containing the given files and writes
+// the image of every container in them to stdout
+func listImages(ctx context.Context, opts options, format string, filepaths []string) error {
+	if format != imagesFormatText && format != imagesFormatJSON {
+		return fmt.Errorf(
+			"unknown format '%s', must be one of '%s' or '%s'",
+			format,
+			imagesFormatText,
+			imagesFormatJSON,
+		)
+	}
+
+	manifestMap, err := buildDirs(ctx, opts, filepaths, newRunReport())
+	if err != nil {
+		return err
+	}
+
+	images, err := findImageRefs(manifestMap)
+	if err != nil {
+		return err
+	}
+
+	if format == imagesFormatJSON {
+		return writeImagesJSON(stdout, images)
+	}
+	return writeImagesText(stdout, images)
+}
+
+// findImageRefs returns the image of every container in the built manifests,
+// ordered by root, then object, then container
+func findImageRefs(manifestMap map[string]string) ([]imageRef, error) {
+	images := []imageRef{}
+	for _, root := range sortedRoots(manifestMap) {
+		objects, err := decodeObjects(manifestMap[root])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing manifests built from %s: %v", root, err)
+		}
+
+		var rootImages []imageRef
+		for _, obj := range objects {
+			for _, c := range findContainers(obj.Object) {
+				repository, tag, digest := parseImage(c.image)
+				rootImages = append(rootImages, imageRef{
+					Root:          root,
+					Kind:          obj.GetKind(),
+					Namespace:     obj.GetNamespace(),
+					Name:          obj.GetName(),
+					ContainerType: c.field,
+					Container:     c.name,
+					Image:         c.image,
+					Repository:    repository,
+					Tag:           tag,
+					Digest:        digest,
+				})
+			}
+		}
+		sort.SliceStable(rootImages, func(i, j int) bool {
+			a, b := rootImages[i], rootImages[j]
+			if a.Kind != b.Kind {
+				return a.Kind < b.Kind
+			}
+			if a.Namespace != b.Namespace {
+				return a.Namespace < b.Namespace
+			}
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			if a.ContainerType != b.ContainerType {
+				return a.ContainerType < b.ContainerType
+			}
+			return a.Container < b.Container
+		})
+		images = append(images, rootImages...)
+	}
+	return images, nil
+}
+
+// parseImage splits an image reference, such as
+// 'registry.example.com/app:v1@sha256:...', into its repository, tag and
+// digest. The tag and digest are empty when not given
+func parseImage(image string) (string, string, string) {
+	repository, digest, _ := strings.Cut(image, "@")
+
+	tag := ""
+	// a ':' before the last '/' separates a registry's host and port
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, tag = repository[:i], repository[i+1:]
+	}
+	return repository, tag, digest
+}
+
+func writeImagesText(w io.Writer, images []imageRef) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROOT\tOBJECT\tCONTAINER\tIMAGE")
+	for _, image := range images {
+		object := image.Kind + " " + image.Name
+		if image.Namespace != "" {
+			object = image.Kind + " " + image.Namespace + "/" + image.Name
+		}
+		container := image.Container
+		if image.ContainerType != "containers" {
+			container = fmt.Sprintf("%s (%s)", image.Container, image.ContainerType)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", image.Root, object, container, image.Image)
+	}
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("error writing images to stdout: %v", err)
+	}
+	return nil
+}
+
+func writeImagesJSON(w io.Writer, images []imageRef) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(images); err != nil {
+		return fmt.Errorf("error writing images to stdout: %v", err)
+	}
+	return nil
+}
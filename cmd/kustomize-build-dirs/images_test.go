@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImage(t *testing.T) {
+	tests := []struct {
+		image              string
+		expectedRepository string
+		expectedTag        string
+		expectedDigest     string
+	}{
+		{
+			image:              "busybox",
+			expectedRepository: "busybox",
+		},
+		{
+			image:              "busybox:1.36",
+			expectedRepository: "busybox",
+			expectedTag:        "1.36",
+		},
+		{
+			image:              "registry.example.com:5000/team/app",
+			expectedRepository: "registry.example.com:5000/team/app",
+		},
+		{
+			image:              "registry.example.com:5000/team/app:v1",
+			expectedRepository: "registry.example.com:5000/team/app",
+			expectedTag:        "v1",
+		},
+		{
+			image:              "registry.example.com/app@sha256:0123",
+			expectedRepository: "registry.example.com/app",
+			expectedDigest:     "sha256:0123",
+		},
+		{
+			image:              "registry.example.com/app:v1@sha256:0123",
+			expectedRepository: "registry.example.com/app",
+			expectedTag:        "v1",
+			expectedDigest:     "sha256:0123",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			repository, tag, digest := parseImage(tt.image)
+			require.Equal(t, tt.expectedRepository, repository)
+			require.Equal(t, tt.expectedTag, tag)
+			require.Equal(t, tt.expectedDigest, digest)
+		})
+	}
+}
+
+func TestFindImageRefs(t *testing.T) {
+	manifestMap := map[string]string{
+		"manifests": summaryManifest,
+		"other":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n",
+	}
+	expected := `ROOT       OBJECT                 CONTAINER              IMAGE
+manifests  CronJob other-ns/job   job                    registry.example.com/app:v1
+manifests  Deployment my-ns/app   app                    registry.example.com/app:v1
+manifests  Deployment my-ns/app   sidecar                registry.example.com/sidecar@sha256:0123
+manifests  Deployment my-ns/app   init (initContainers)  busybox:1.36
+manifests  Rollout my-ns/rollout  rollout                registry.example.com/rollout:v2
+`
+
+	images, err := findImageRefs(manifestMap)
+	require.NoError(t, err)
+	require.Len(t, images, 5)
+	require.Equal(
+		t,
+		imageRef{
+			Root:          "manifests",
+			Kind:          "Deployment",
+			Namespace:     "my-ns",
+			Name:          "app",
+			ContainerType: "containers",
+			Container:     "sidecar",
+			Image:         "registry.example.com/sidecar@sha256:0123",
+			Repository:    "registry.example.com/sidecar",
+			Digest:        "sha256:0123",
+		},
+		images[2],
+	)
+
+	var out strings.Builder
+	require.NoError(t, writeImagesText(&out, images))
+	require.Equal(t, expected, out.String())
+}
+
+func TestListImages(t *testing.T) {
+	gitDir, _ := setupTest(t)
+	out := setStdout(t)
+
+	manifestPath := filepath.Join("manifests", "deployment.yaml")
+	deployment := simpleDeployment + `spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          image: app:v1
+`
+	repoFiles := map[string]string{
+		filepath.Join("manifests", "kustomization.yaml"): simpleKustomization,
+		manifestPath: deployment,
+	}
+	buildGitRepo(t, gitDir, repoFiles)
+	expected := `[
+  {
+    "root": "manifests",
+    "kind": "Deployment",
+    "name": "my-cool-app",
+    "containerType": "containers",
+    "container": "app",
+    "image": "app:v1",
+    "repository": "app",
+    "tag": "v1"
+  }
+]
+`
+
+	require.NoError(
+		t,
+		listImages(context.Background(), options{dirDepth: mockdirDepth}, imagesFormatJSON, []string{manifestPath}),
+	)
+	require.Equal(t, expected, out.String())
+}
+
+func TestListImagesFailsOnUnknownFormat(t *testing.T) {
+	err := listImages(context.Background(), options{}, "yaml", []string{})
+
+	require.EqualError(t, err, "unknown format 'yaml', must be one of 'text' or 'json'")
+}
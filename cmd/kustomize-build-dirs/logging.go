@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// newLogger creates a logger writing to w in the given format. By default
+// info and above is logged, 'quiet' restricts this to warnings and errors and
+// 'verbose' extends it to debug messages
+func newLogger(w io.Writer, format string, quiet bool, verbose bool) (*slog.Logger, error) {
+	var level slog.Level
+	switch {
+	case quiet && verbose:
+		return nil, fmt.Errorf("'--quiet' and '--verbose' can't be used together")
+	case quiet:
+		level = slog.LevelWarn
+	case verbose:
+		level = slog.LevelDebug
+	default:
+		level = slog.LevelInfo
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+
+	switch format {
+	case logFormatText:
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	case logFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	default:
+		return nil, fmt.Errorf(
+			"unknown log format '%s', must be one of '%s' or '%s'",
+			format,
+			logFormatText,
+			logFormatJSON,
+		)
+	}
+}
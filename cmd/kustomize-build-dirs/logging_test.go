@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLoggerFailsOnUnknownFormat(t *testing.T) {
+	_, err := newLogger(&strings.Builder{}, "xml", false, false)
+
+	require.EqualError(t, err, "unknown log format 'xml', must be one of 'text' or 'json'")
+}
+
+func TestNewLoggerFailsWhenQuietAndVerbose(t *testing.T) {
+	_, err := newLogger(&strings.Builder{}, logFormatText, true, true)
+
+	require.EqualError(t, err, "'--quiet' and '--verbose' can't be used together")
+}
+
+func TestNewLoggerLevels(t *testing.T) {
+	tests := []struct {
+		name     string
+		quiet    bool
+		verbose  bool
+		expected []string
+	}{
+		{
+			name:     "default",
+			expected: []string{"info", "warning"},
+		},
+		{
+			name:     "quiet",
+			quiet:    true,
+			expected: []string{"warning"},
+		},
+		{
+			name:     "verbose",
+			verbose:  true,
+			expected: []string{"debug", "info", "warning"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			logger, err := newLogger(&out, logFormatJSON, tt.quiet, tt.verbose)
+			require.NoError(t, err)
+
+			logger.Debug("debug")
+			logger.Info("info")
+			logger.Warn("warning")
+
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				var record map[string]any
+				require.NoError(t, json.Unmarshal([]byte(line), &record))
+				got = append(got, record["msg"].(string))
+			}
+			require.Equal(t, tt.expected, got)
+		})
+	}
+}
@@ -1,390 +1,432 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"sort"
+	"regexp"
 	"strings"
-	"sync"
+	"syscall"
 
 	"github.com/urfave/cli/v2"
-	"golang.org/x/sync/errgroup"
-	"gopkg.in/yaml.v2"
+
+	"github.com/utilitywarehouse/manifest-checkers/kustomizebuild"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	manifestFileName = "manifests.yaml"
+	// stdoutOutDir is the value of '--out-dir' that streams all built
+	// manifests to stdout instead of writing them to files
+	stdoutOutDir = "-"
 )
 
-const manifestFileName = "manifests.yaml"
+// options configures a run of kustomizeBuildDirs
+type options struct {
+	outDir            string
+	dirDepth          int
+	doTruncateSecrets bool
+	// limits bounds the manifests built from each kustomization
+	limits kustomizebuild.Limits
+	// env configures the environment `kustomize build` runs with
+	env kustomizebuild.EnvConfig
+	// rootMarker is the name of a file marking the directories that are
+	// deployable kustomization roots. When set, directories containing a
+	// 'kustomization.yaml' but no marker aren't built on their own
+	rootMarker string
+	// warningsAsErrors fails the build of any kustomization for which
+	// `kustomize build` reports a warning not matched by allowedWarnings
+	warningsAsErrors bool
+	allowedWarnings  []*regexp.Regexp
+	// clusterDepth is the number of leading directories of a kustomization
+	// root that identify the cluster it targets, used to check no two roots
+	// build the same object into a cluster. 0 disables the check
+	clusterDepth int
+	// validateSchemas validates built objects against their OpenAPI schemas,
+	// read from schemaFile if set
+	validateSchemas bool
+	schemaFile      string
+	// ref is a git commit to build from instead of the working tree
+	ref string
+	// writeSummaries writes a summary of the objects built alongside the
+	// manifests
+	writeSummaries bool
+	// summaryMarkdown is a file to write a markdown summary of the run to
+	summaryMarkdown string
+}
 
-// Kustomization represents the structure of a Kustomization file
-type Kustomization struct {
-	APIVersion string `yaml:"apiVersion"`
-	Kind       string `yaml:"kind"`
+// buildOptions returns the options for building kustomizations in the repo at
+// repoDir
+func (o options) buildOptions(repoDir string) kustomizebuild.Options {
+	return kustomizebuild.Options{
+		RepoDir:          repoDir,
+		MinDepth:         o.dirDepth,
+		RootMarker:       o.rootMarker,
+		TruncateSecrets:  o.doTruncateSecrets,
+		WarningsAsErrors: o.warningsAsErrors,
+		AllowedWarnings:  o.allowedWarnings,
+		Env:              o.env,
+		Limits:           o.limits,
+	}
 }
 
-// variable used for testing
-var getwdFunc = os.Getwd
+// variables used for testing
+var (
+	getwdFunc           = os.Getwd
+	stdout    io.Writer = os.Stdout
+)
 
 func main() {
-	var opts struct {
-		outDir            string
-		dirDepth          int
-		doTruncateSecrets bool
-	}
+	var (
+		opts            options
+		allowedWarnings cli.StringSlice
+		logFormat       string
+		quiet           bool
+		verbose         bool
+		imagesFormat    string
+		envConfig       string
+		maxManifestSize string
+		maxConfigSize   string
+	)
 	app := &cli.App{
 		Name:  "kustomize-build-dirs",
 		Usage: "Given a list of input files, run `kustomize build` somewhere",
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:        "out-dir",
-				Required:    true,
-				Usage:       "Directory to output build manifests",
+				Usage:       "Directory to output build manifests, or '-' to write them all to stdout. Required unless running a command",
 				Destination: &opts.outDir,
 			},
+			&cli.StringFlag{
+				Name:        "ref",
+				Usage:       "Git commit to build the kustomizations from, rather than the working tree, which is left untouched",
+				Destination: &opts.ref,
+			},
 			&cli.IntFlag{
 				Name:        "depth",
 				Value:       0,
 				Usage:       "Minimum directory depth to work with (e.g., 2 means paths will be at least two levels deep like 'aaa/bbb/')",
 				Destination: &opts.dirDepth,
 			},
+			&cli.StringFlag{
+				Name:        "root-marker",
+				Usage:       "Name of a file marking deployable kustomizations. When set, changed files are built from the nearest parent directory with both a 'kustomization.yaml' and this file",
+				Destination: &opts.rootMarker,
+			},
 			&cli.BoolFlag{
 				Name:        "truncate-secrets",
 				Value:       false,
 				Usage:       "Whether or not to truncate secrets. This can make life easier when you don't have strongbox credentials for some secrets",
 				Destination: &opts.doTruncateSecrets,
 			},
+			&cli.StringFlag{
+				Name:        "env-config",
+				Usage:       "YAML file setting environment variables for 'kustomize build' by directory. Otherwise builds only see a minimal environment",
+				Destination: &envConfig,
+			},
+			&cli.BoolFlag{
+				Name:        "warnings-as-errors",
+				Value:       false,
+				Usage:       "Fail when 'kustomize build' reports a warning that isn't matched by '--allow-warning'",
+				Destination: &opts.warningsAsErrors,
+			},
+			&cli.StringSliceFlag{
+				Name:        "allow-warning",
+				Usage:       "Regular expression matching a 'kustomize build' warning that shouldn't fail the build with '--warnings-as-errors'. May be given multiple times",
+				Destination: &allowedWarnings,
+			},
+			&cli.StringFlag{
+				Name:        "max-manifest-size",
+				Usage:       "Maximum size of the manifests built from a kustomization, e.g. '10Mi'. Unlimited by default",
+				Destination: &maxManifestSize,
+			},
+			&cli.IntFlag{
+				Name:        "max-objects",
+				Value:       0,
+				Usage:       "Maximum number of objects built from a kustomization. 0 is unlimited",
+				Destination: &opts.limits.MaxObjects,
+			},
+			&cli.StringFlag{
+				Name:        "max-config-size",
+				Usage:       "Maximum size of any ConfigMap or Secret built, e.g. '1Mi' as limited by the API server. Unlimited by default",
+				Destination: &maxConfigSize,
+			},
+			&cli.IntFlag{
+				Name:        "cluster-depth",
+				Value:       0,
+				Usage:       "Number of leading directories identifying the cluster a kustomization targets. When set, fail if multiple kustomizations for the same cluster build the same object. Only the kustomizations built in the run are compared, not others for the same cluster that are unchanged",
+				Destination: &opts.clusterDepth,
+			},
+			&cli.BoolFlag{
+				Name:        "summary",
+				Value:       false,
+				Usage:       "Write a summary of the objects built from each kustomization next to its manifests, and of all objects built to the root of '--out-dir'",
+				Destination: &opts.writeSummaries,
+			},
+			&cli.StringFlag{
+				Name:        "summary-markdown",
+				Usage:       "File to write a markdown summary of the run to, e.g. for posting as a PR comment. Written even if the run fails",
+				Destination: &opts.summaryMarkdown,
+			},
+			&cli.BoolFlag{
+				Name:        "validate-schemas",
+				Value:       false,
+				Usage:       "Validate built objects against the OpenAPI schemas of builtin kinds and of CustomResourceDefinitions found in the repo",
+				Destination: &opts.validateSchemas,
+			},
+			&cli.StringFlag{
+				Name:        "schema-file",
+				Usage:       "OpenAPI v2 spec ('swagger.json') of the Kubernetes version to validate builtin kinds against with '--validate-schemas'. Defaults to the bundled schemas",
+				Destination: &opts.schemaFile,
+			},
+			&cli.StringFlag{
+				Name:        "log-format",
+				Value:       logFormatText,
+				Usage:       "Format of log lines written to stderr, one of 'text' or 'json'",
+				Destination: &logFormat,
+			},
+			&cli.BoolFlag{
+				Name:        "quiet",
+				Value:       false,
+				Usage:       "Only log warnings and errors",
+				Destination: &quiet,
+			},
+			&cli.BoolFlag{
+				Name:        "verbose",
+				Value:       false,
+				Usage:       "Also log debug messages",
+				Destination: &verbose,
+			},
+		},
+		Before: func(c *cli.Context) error {
+			logger, err := newLogger(os.Stderr, logFormat, quiet, verbose)
+			if err != nil {
+				return err
+			}
+			slog.SetDefault(logger)
+
+			if opts.limits.MaxBytes, err = parseSize("max-manifest-size", maxManifestSize); err != nil {
+				return err
+			}
+			if opts.limits.MaxConfigBytes, err = parseSize("max-config-size", maxConfigSize); err != nil {
+				return err
+			}
+
+			if envConfig != "" {
+				opts.env, err = kustomizebuild.LoadEnvConfig(envConfig)
+				if err != nil {
+					return err
+				}
+			}
+
+			opts.allowedWarnings, err = compileWarningPatterns(allowedWarnings.Value())
+			return err
 		},
 		Action: func(c *cli.Context) error {
-			return kustomizeBuildDirs(
-				opts.outDir,
-				opts.dirDepth,
-				opts.doTruncateSecrets,
-				c.Args().Slice(),
-			)
+			if opts.outDir == "" {
+				return errors.New(`Required flag "out-dir" not set`)
+			}
+			return kustomizeBuildDirs(c.Context, opts, c.Args().Slice())
+		},
+		Commands: []*cli.Command{
+			{
+				Name:      "images",
+				Usage:     "List the image of every container in the built manifests",
+				ArgsUsage: "[file...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "format",
+						Value:       imagesFormatText,
+						Usage:       "Output format, one of 'text' or 'json'",
+						Destination: &imagesFormat,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return listImages(c.Context, opts, imagesFormat, c.Args().Slice())
+				},
+			},
+			{
+				Name:      "watch",
+				Usage:     "Rebuild kustomize directories as files in the repo change",
+				ArgsUsage: "[file...]",
+				Action: func(c *cli.Context) error {
+					if opts.outDir == "" {
+						return errors.New(`Required flag "out-dir" not set`)
+					}
+					return watch(c.Context, opts, c.Args().Slice())
+				},
+			},
 		},
 	}
 
-	if err := app.Run(os.Args); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	// cancel on Ctrl-C and SIGTERM, so that builds stop and clean up after
+	// themselves, e.g. removing the worktree checked out for '--ref'
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := app.RunContext(ctx, os.Args)
+	stop()
+	if err != nil {
+		slog.Error(err.Error())
 		os.Exit(1)
 	}
 }
 
-func kustomizeBuildDirs(
-	outDir string,
-	dirDepth int,
-	doTruncateSecrets bool,
-	filepaths []string,
-) error {
-	rootDir, err := getwdFunc()
-	if err != nil {
-		return fmt.Errorf("error reading working directory: %v", err)
+func kustomizeBuildDirs(ctx context.Context, opts options, filepaths []string) error {
+	if opts.writeSummaries && opts.outDir == stdoutOutDir {
+		return errors.New("'--summary' can't be used with '--out-dir -'")
 	}
 
-	if err = checkKustomizeInstalled(); err != nil {
-		return err
+	report := newRunReport()
+	manifestMap, err := buildDirs(ctx, opts, filepaths, report)
+	if opts.summaryMarkdown != "" {
+		if reportErr := report.writeMarkdown(opts.summaryMarkdown, err); reportErr != nil {
+			if err != nil {
+				slog.Error(reportErr.Error())
+				return err
+			}
+			return reportErr
+		}
 	}
-
-	kustomizationRoots, err := findKustomizationRoots(rootDir, filepaths, dirDepth)
 	if err != nil {
 		return err
 	}
 
-	kustomizationRoots, err = removeComponentKustomizations(rootDir, kustomizationRoots)
-	if err != nil { //go-cov:skip
-		return err
-	}
-
-	// truncate secrets so we can run `kustomize build` without having to decrypt them
-	if doTruncateSecrets {
-		if err := truncateSecrets(rootDir, kustomizationRoots); err != nil {
-			return err
-		}
-	}
-
-	manifestMap, err := buildManifests(kustomizationRoots, rootDir)
-	if err != nil {
-		return err
+	if opts.outDir == stdoutOutDir {
+		return writeManifestStream(stdout, manifestMap)
 	}
 
 	for manifestPath, manifest := range manifestMap {
-		if err := writeManifest(manifest, outDir, manifestPath); err != nil {
+		if err := writeManifest(manifest, opts.outDir, manifestPath); err != nil {
 			return err
 		}
 	}
 
-	return nil
-}
-
-func checkKustomizeInstalled() error {
-	if _, err := exec.LookPath("kustomize"); err != nil {
-		return errors.New(
-			"requires `kustomize` to be installed https://kubectl.docs.kubernetes.io/installation/kustomize/",
-		)
+	if opts.writeSummaries {
+		return writeSummaries(opts.outDir, manifestMap)
 	}
 	return nil
 }
 
-// deepestCommonDirs takes a list of file paths and returns a list of directory paths
-// that represent the deepest common directory for each group of similarly prefixed files
-// with a minimum directory depth enforced.
-// Example (with minDepth = 2):
-// Input: ["aaa/file.yaml", "aaa/bbb/file.yaml", "bbb/ccc/file1.yaml", "bbb/ccc/file2.yaml"]
-// Output: ["aaa/bbb/", "bbb/ccc/"]
-func deepestCommonDirs(paths []string, minDepth int) []string {
-	if len(paths) == 0 {
-		return nil
-	}
-
-	dirSet := make(map[string]struct{})
-
-	for _, path := range paths {
-		dir := filepath.ToSlash(filepath.Dir(path))
-		if dir == "." {
-			// File in root directory is represented as empty string
-			dir = ""
-		}
-		dirSet[dir] = struct{}{}
-	}
-
-	// Filter out directories that are shallower than minDepth
-	var dirs []string
-	for dir := range dirSet {
-		segments := strings.Split(dir, "/")
-		if dir == "" {
-			segments = []string{}
-		}
-		if len(segments) >= minDepth {
-			dirs = append(dirs, dir)
-		}
-	}
-	sort.Strings(dirs) // Sort for consistent and hierarchical comparison
-
-	var result []string
-	skipPrefixes := make(map[string]struct{})
-
-	for _, dir := range dirs {
-		skip := false
-
-		for parent := range skipPrefixes {
-			if strings.HasPrefix(dir+"/", parent+"/") {
-				skip = true
-				break
-			}
-		}
-
-		if !skip {
-			skipPrefixes[dir] = struct{}{}
-			if dir == "" {
-				result = append(result, "")
-			} else {
-				result = append(result, dir+"/")
-			}
-		}
+// buildDirs builds the kustomizations containing the given files and runs any
+// enabled checks on them, returning the built manifests by kustomization root.
+// The outcome for each directory is recorded in report
+func buildDirs(
+	ctx context.Context,
+	opts options,
+	filepaths []string,
+	report *runReport,
+) (map[string]string, error) {
+	rootDir, err := getwdFunc()
+	if err != nil {
+		return nil, fmt.Errorf("error reading working directory: %v", err)
 	}
 
-	return result
-}
-
-// findKustomizationRoots finds, for each given path, the first parent
-// directory containing a 'kustomization.yaml'. It returns a list of such paths
-// relative to the root
-func findKustomizationRoots(root string, paths []string, dirDepth int) ([]string, error) {
-	// Group paths by shared prefixes and return their deepest common directories
-	paths = deepestCommonDirs(paths, dirDepth)
-
-	// there may be multiple changes under the same path
-	// so use a map to track unique ones
-	rootsMap := map[string]struct{}{}
-	for _, path := range paths {
-		kustomizationRoot, err := findKustomizationRoot(root, path)
+	if opts.ref != "" {
+		worktreeDir, cleanup, err := checkoutRef(rootDir, opts.ref)
 		if err != nil {
 			return nil, err
 		}
-		if kustomizationRoot == "" {
-			continue
-		}
-
-		if _, exists := rootsMap[kustomizationRoot]; !exists {
-			fmt.Printf("Found kustomization build dir: %s\n", kustomizationRoot)
-			rootsMap[kustomizationRoot] = struct{}{}
-		}
+		defer cleanup()
+		rootDir = worktreeDir
 	}
 
-	roots := make([]string, 0, len(rootsMap))
-	for root := range rootsMap {
-		roots = append(roots, root)
+	result, err := kustomizebuild.Build(ctx, opts.buildOptions(rootDir), filepaths)
+	report.addResult(result, opts.dirDepth)
+	if err != nil {
+		return nil, err
 	}
-	return roots, nil
-}
+	manifestMap := result.Manifests
 
-func findKustomizationRoot(repoRoot string, relativePath string) (string, error) {
-	for dir := filepath.Dir(relativePath); dir != ".."; dir = filepath.Clean(filepath.Join(dir, "..")) {
-		_, err := os.Stat(filepath.Join(repoRoot, dir, "kustomization.yaml"))
-		switch {
-		case err == nil:
-			// found 'kustomization.yaml'
-			return dir, nil
-		case !os.IsNotExist(err):
-			return "", fmt.Errorf("error checking for file in %s: %v", dir, err)
-		default:
-			// file not found, continue up the directory tree
-			continue
+	if opts.clusterDepth > 0 {
+		if err := findDuplicateObjects(manifestMap, opts.clusterDepth); err != nil {
+			return nil, err
 		}
 	}
-	return "", nil
-}
 
-// removeComponentKustomizations checks the list of the kustomization files, and removes those with
-// kind: Component.
-// We can't expect standalone Component kustomization files to correctly render.
-func removeComponentKustomizations(kustomizationRoot string, paths []string) ([]string, error) {
-	pathsNoComponent := []string{}
-	for _, path := range paths {
-		isComponent, err := checkIfIsComponent(
-			filepath.Join(kustomizationRoot, path, "kustomization.yaml"),
-		)
-		if err != nil { //go-cov:skip
+	if opts.validateSchemas {
+		if err := validateSchemas(rootDir, opts.schemaFile, manifestMap); err != nil {
 			return nil, err
 		}
-		if !isComponent {
-			pathsNoComponent = append(pathsNoComponent, path)
-		}
-	}
-	return pathsNoComponent, nil
-}
-
-func checkIfIsComponent(filepath string) (bool, error) {
-	file, err := os.Open(filepath)
-	if err != nil { //go-cov:skip
-		return false, fmt.Errorf("failed opening kustomization file: %s: %v", filepath, err)
-	}
-	defer file.Close()
-
-	// Read the file's content
-	data, err := io.ReadAll(file)
-	if err != nil { //go-cov:skip
-		return false, fmt.Errorf("error reading file: %v", err)
 	}
 
-	// Unmarshal the YAML into the struct
-	var kustomization Kustomization
-	err = yaml.Unmarshal(data, &kustomization)
-	if err != nil { //go-cov:skip
-		return false, fmt.Errorf("error unmarshaling YAML: %v", err)
-	}
-	return kustomization.Kind == "Component", nil
+	return manifestMap, nil
 }
 
-func truncateSecrets(rootDir string, dirs []string) error {
-	secrets, err := findSecrets(rootDir, dirs)
+// checkoutRef checks out the given git ref of the repo at rootDir into a new
+// temporary worktree, so it can be built without touching the working tree.
+// It returns the worktree's directory and a function to remove it
+func checkoutRef(rootDir string, ref string) (string, func(), error) {
+	worktreeDir, err := os.MkdirTemp("", "kustomize-build-dirs-")
 	if err != nil {
-		return err
+		return "", nil, fmt.Errorf("error creating directory for worktree: %v", err)
 	}
 
-	for _, secret := range secrets {
-		file, err := os.OpenFile(filepath.Join(rootDir, secret), os.O_TRUNC, 0o002)
-		if err != nil {
-			return fmt.Errorf("error truncating secrets file '%s': %v", secret, err)
+	args := []string{"-C", rootDir, "worktree", "add", "--detach", worktreeDir, ref}
+	if stderr, err := runGit(args); err != nil {
+		os.RemoveAll(worktreeDir) //nolint:errcheck
+		return "", nil, fmt.Errorf(
+			"Error checking out '%s' via 'git %s': %v\nstderr: %s",
+			ref,
+			strings.Join(args, " "),
+			err,
+			stderr,
+		)
+	}
+	slog.Debug("checked out ref", "ref", ref, "path", worktreeDir)
+
+	cleanup := func() {
+		args := []string{"-C", rootDir, "worktree", "remove", "--force", worktreeDir}
+		if stderr, err := runGit(args); err != nil {
+			slog.Warn(
+				"failed removing worktree",
+				"path", worktreeDir,
+				"error", err,
+				"stderr", stderr,
+			)
 		}
-		defer file.Close()
 	}
-
-	return nil
+	return worktreeDir, cleanup, nil
 }
 
-// findSecrets finds files under rootDir, that is assumed to be within a git
-// repo, that appear to be strongbox encoded secrets
-func findSecrets(rootDir string, dirs []string) ([]string, error) {
-	// files that look to be strongbox encrypted based on their git attributes
-	// docs https://git-scm.com/docs/gitglossary#Documentation/gitglossary.txt-aiddefpathspecapathspec
-	encryptedPathspec := ":(attr:filter=strongbox diff=strongbox)"
-	pathspecs := make([]string, len(dirs))
-	for i, dir := range dirs {
-		pathspecs[i] = encryptedPathspec + dir
-	}
-
-	var stdout strings.Builder
+// runGit runs git with the given arguments, returning what it wrote to stderr
+func runGit(args []string) (string, error) {
 	var stderr strings.Builder
-	// "-z" to use null byte as field terminator, in case someone creates a
-	// file with a "\n" in the name (for some reason)
-	// we prepend the pathspec 'not/a/path' that will match nothing to:
-	//	1) Avoid matching everything when dirs is empty
-	//	2) To work around a bug in 'ls-files': https://lore.kernel.org/git/CAEzX-aD1wfgp8AvNNfCXVM3jAaAjK+uFTqS2XP4CJbVvFr2BtQ@mail.gmail.com/
-	args := append([]string{"-C", rootDir, "ls-files", "-z", "--", "not/a/path"}, pathspecs...)
 	cmd := exec.Command("git", args...)
-	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf(
-			"Error listing secrets via 'git %s': %v\nstderr: %s",
-			strings.Join(args, " "),
-			err,
-			stderr.String(),
-		)
-	}
-
-	secrets := strings.Split(stdout.String(), "\x00")
-	// there's always a trailing '\x00' so trim that element
-	return secrets[:len(secrets)-1], nil
+	err := cmd.Run()
+	return stderr.String(), err
 }
 
-func buildManifests(kustomizationRoots []string, rootDir string) (map[string]string, error) {
-	// `kustomize build` can take some time to run, particularly if it needs to
-	// fetch some remote resources, so call it concurrently
-	group := new(errgroup.Group)
-	mutex := new(sync.Mutex)
-	manifestMap := make(map[string]string, len(kustomizationRoots))
-	for i := range kustomizationRoots {
-		kustomizationRoot := kustomizationRoots[i]
-		fmt.Printf("Running `kustomize build %s`\n", kustomizationRoot)
-		group.Go(func() error {
-			manifest, stderr, err := kustomizeBuild(filepath.Join(rootDir, kustomizationRoot))
-			if err != nil {
-				return err
-			}
-			mutex.Lock()
-			fmt.Printf("Built: %s\n", kustomizationRoot)
-			if stderr != "" { //go-cov:skip // version specific warnings might be a pain to test
-				fmt.Fprintf(
-					os.Stderr,
-					"---start Warnings---\nWarnings for: %s\n%s---End warnings---\n",
-					kustomizationRoot,
-					stderr,
-				)
-			}
-			manifestMap[kustomizationRoot] = manifest
-			defer mutex.Unlock()
-			return nil
-		})
+// parseSize parses the value of a size flag, a Kubernetes quantity such as
+// '1Mi', to a number of bytes. An empty value is 0, or unlimited
+func parseSize(flag string, value string) (int64, error) {
+	if value == "" {
+		return 0, nil
 	}
-	if err := group.Wait(); err != nil {
-		return nil, err
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid '--%s' size '%s': %v", flag, value, err)
 	}
-	return manifestMap, nil
+	return quantity.Value(), nil
 }
 
-func kustomizeBuild(path string) (string, string, error) {
-	var stdout strings.Builder
-	var stderr strings.Builder
-	args := []string{"build", path}
-	cmd := exec.Command("kustomize", args...)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return "", "", fmt.Errorf(
-			"Error running 'kustomize %s': %v\nstderr: %s",
-			strings.Join(args, " "),
-			err,
-			stderr.String(),
-		)
+// compileWarningPatterns compiles the patterns given via '--allow-warning'
+func compileWarningPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid '--allow-warning' pattern '%s': %v", pattern, err)
+		}
+		compiled[i] = re
 	}
-
-	return stdout.String(), stderr.String(), nil
+	return compiled, nil
 }
 
 func writeManifest(manifest string, outDir string, manifestPath string) error {
@@ -399,3 +441,20 @@ func writeManifest(manifest string, outDir string, manifestPath string) error {
 	}
 	return nil
 }
+
+// writeManifestStream writes every manifest to w as a single multi-document
+// YAML stream, ordered by kustomization root. Each document is preceded by a
+// '# Source: <root>' comment so it can be traced back to where it was built
+func writeManifestStream(w io.Writer, manifestMap map[string]string) error {
+	var stream strings.Builder
+	for _, root := range sortedRoots(manifestMap) {
+		for _, document := range kustomizebuild.SplitDocuments(manifestMap[root]) {
+			fmt.Fprintf(&stream, "---\n# Source: %s\n%s", root, document)
+		}
+	}
+
+	if _, err := io.WriteString(w, stream.String()); err != nil {
+		return fmt.Errorf("error writing manifests to stdout: %v", err)
+	}
+	return nil
+}
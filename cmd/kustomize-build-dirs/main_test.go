@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -11,6 +12,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/utilitywarehouse/manifest-checkers/kustomizebuild"
 )
 
 const (
@@ -100,7 +102,7 @@ func TestFailsWhenUnableToReadWorkingDirectory(t *testing.T) {
 	defer func() { getwdFunc = orig }()
 	getwdFunc = getwd
 
-	err := kustomizeBuildDirs(mockoutDir, mockdirDepth, false, []string{})
+	err := kustomizeBuildDirs(context.Background(), options{outDir: mockoutDir, dirDepth: mockdirDepth}, []string{})
 
 	require.EqualError(t, err, expectedError)
 }
@@ -108,7 +110,7 @@ func TestFailsWhenUnableToReadWorkingDirectory(t *testing.T) {
 func TestFailsWhenUnableToFindKustomize(t *testing.T) {
 	expectedError := "requires `kustomize` to be installed https://kubectl.docs.kubernetes.io/installation/kustomize/"
 	t.Setenv("PATH", "")
-	err := kustomizeBuildDirs(mockoutDir, mockdirDepth, false, []string{})
+	err := kustomizeBuildDirs(context.Background(), options{outDir: mockoutDir, dirDepth: mockdirDepth}, []string{})
 
 	require.EqualError(t, err, expectedError)
 }
@@ -125,7 +127,11 @@ func TestFailsWhenUnableToListSecrets(t *testing.T) {
 	)
 
 	// run command outside any Git directory
-	err = kustomizeBuildDirs(mockoutDir, mockdirDepth, true, []string{"kustomization.yaml"})
+	err = kustomizeBuildDirs(
+		context.Background(),
+		options{outDir: mockoutDir, dirDepth: mockdirDepth, doTruncateSecrets: true},
+		[]string{"kustomization.yaml"},
+	)
 	requireErorrPrefix(t, err, expectedErrPrefix)
 }
 
@@ -144,7 +150,11 @@ func TestFailsWhenUnableToTruncateSecret(t *testing.T) {
 	// make secret file read-only
 	require.NoError(t, os.Chmod(filepath.Join(gitDir, secretFile), 0o400))
 
-	err := kustomizeBuildDirs(mockoutDir, mockdirDepth, true, []string{"kustomization.yaml"})
+	err := kustomizeBuildDirs(
+		context.Background(),
+		options{outDir: mockoutDir, dirDepth: mockdirDepth, doTruncateSecrets: true},
+		[]string{"kustomization.yaml"},
+	)
 
 	requireErorrPrefix(t, err, expectedErrPrefix)
 }
@@ -164,9 +174,8 @@ func TestFailsWhenUnableToFindKustomizations(t *testing.T) {
 	expectedErrPrefix := "error checking for file in manifests:"
 
 	err := kustomizeBuildDirs(
-		mockoutDir,
-		mockdirDepth,
-		false,
+		context.Background(),
+		options{outDir: mockoutDir, dirDepth: mockdirDepth},
 		[]string{"manifests/kustomization.yaml"},
 	)
 	requireErorrPrefix(t, err, expectedErrPrefix)
@@ -187,7 +196,11 @@ func TestFailsOnKustomizeBuildFailure(t *testing.T) {
 		filepath.Join(gitDir, kustomizeDir),
 	)
 
-	err := kustomizeBuildDirs(mockoutDir, mockdirDepth, false, []string{kustomizationPath})
+	err := kustomizeBuildDirs(
+		context.Background(),
+		options{outDir: mockoutDir, dirDepth: mockdirDepth},
+		[]string{kustomizationPath},
+	)
 
 	requireErorrPrefix(t, err, expectedErrPrefix)
 }
@@ -209,7 +222,11 @@ func TestFailsWhenUnableToCreateTargetDir(t *testing.T) {
 	}
 	buildGitRepo(t, gitDir, repoFiles)
 
-	err := kustomizeBuildDirs(unwritableDir, mockdirDepth, false, []string{deploymentPath})
+	err := kustomizeBuildDirs(
+		context.Background(),
+		options{outDir: unwritableDir, dirDepth: mockdirDepth},
+		[]string{deploymentPath},
+	)
 	requireErorrPrefix(t, err, expectedErrPrefix)
 }
 
@@ -240,7 +257,11 @@ func TestFailsWhenFailingToWriteManifest(t *testing.T) {
 	}
 	buildGitRepo(t, gitDir, repoFiles)
 
-	err := kustomizeBuildDirs(outDir, mockdirDepth, false, []string{deploymentPath})
+	err := kustomizeBuildDirs(
+		context.Background(),
+		options{outDir: outDir, dirDepth: mockdirDepth},
+		[]string{deploymentPath},
+	)
 	requireErorrPrefix(t, err, expectedErrPrefix)
 }
 
@@ -254,7 +275,14 @@ func TestDoesNothingWhenNothingToBuild(t *testing.T) {
 	}
 	buildGitRepo(t, gitDir, repoFiles)
 
-	require.NoError(t, kustomizeBuildDirs(outDir, mockdirDepth, true, []string{"README.md"}))
+	require.NoError(
+		t,
+		kustomizeBuildDirs(
+			context.Background(),
+			options{outDir: outDir, dirDepth: mockdirDepth, doTruncateSecrets: true},
+			[]string{"README.md"},
+		),
+	)
 
 	require.NoFileExists(t, outDir)
 	// sanity check no unexpected truncates
@@ -287,7 +315,11 @@ secretGenerator:
 	buildGitRepo(t, gitDir, repoFiles)
 	require.NoError(
 		t,
-		kustomizeBuildDirs(outDir, mockdirDepth, true, []string{"kustomization.yaml"}),
+		kustomizeBuildDirs(
+			context.Background(),
+			options{outDir: outDir, dirDepth: mockdirDepth, doTruncateSecrets: true},
+			[]string{"kustomization.yaml"},
+		),
 	)
 }
 
@@ -336,7 +368,14 @@ func TestDontRenderComponent(t *testing.T) {
 	}
 	buildGitRepo(t, gitDir, repoFiles)
 
-	require.NoError(t, kustomizeBuildDirs(outDir, mockdirDepth, false, []string{manifestPath}))
+	require.NoError(
+		t,
+		kustomizeBuildDirs(
+			context.Background(),
+			options{outDir: outDir, dirDepth: mockdirDepth},
+			[]string{manifestPath},
+		),
+	)
 	require.NoFileExists(t, outDir)
 }
 
@@ -353,7 +392,14 @@ func TestWriteSingleManifest(t *testing.T) {
 		"manifests": simpleDeployment,
 	}
 
-	require.NoError(t, kustomizeBuildDirs(outDir, mockdirDepth, false, []string{manifestPath}))
+	require.NoError(
+		t,
+		kustomizeBuildDirs(
+			context.Background(),
+			options{outDir: outDir, dirDepth: mockdirDepth},
+			[]string{manifestPath},
+		),
+	)
 	compareResults(t, outDir, expectedContents, readOutDir(t, outDir))
 }
 
@@ -372,7 +418,14 @@ func TestWritesManifestWhenGivenNonManifestFile(t *testing.T) {
 		"manifests": simpleDeployment,
 	}
 
-	require.NoError(t, kustomizeBuildDirs(outDir, mockdirDepth, false, []string{nonManifestPath}))
+	require.NoError(
+		t,
+		kustomizeBuildDirs(
+			context.Background(),
+			options{outDir: outDir, dirDepth: mockdirDepth},
+			[]string{nonManifestPath},
+		),
+	)
 	compareResults(t, outDir, expectedContents, readOutDir(t, outDir))
 }
 
@@ -398,9 +451,8 @@ func TestWriteMultipleManifests(t *testing.T) {
 	require.NoError(
 		t,
 		kustomizeBuildDirs(
-			outDir,
-			mockdirDepth,
-			false,
+			context.Background(),
+			options{outDir: outDir, dirDepth: mockdirDepth},
 			[]string{firstDeploymentPath, secondDeploymentPath},
 		),
 	)
@@ -428,9 +480,8 @@ func TestWriteMultipleManifestsOneIscomponent(t *testing.T) {
 	require.NoError(
 		t,
 		kustomizeBuildDirs(
-			outDir,
-			mockdirDepth,
-			false,
+			context.Background(),
+			options{outDir: outDir, dirDepth: mockdirDepth},
 			[]string{firstDeploymentPath, secondDeploymentPath},
 		),
 	)
@@ -468,147 +519,283 @@ resources:
 	require.NoError(
 		t,
 		kustomizeBuildDirs(
-			outDir,
-			mockdirDepth,
-			true,
+			context.Background(),
+			options{outDir: outDir, dirDepth: mockdirDepth, doTruncateSecrets: true},
 			[]string{filepath.Join(manifestsDir, "kustomization.yaml")},
 		),
 	)
 	compareResults(t, outDir, expectedContents, readOutDir(t, outDir))
 }
 
-func TestDeepestCommonDirs(t *testing.T) {
-	tests := []struct {
-		name     string
-		input    []string
-		minDepth int
-		expected []string
-	}{
-		{
-			name:     "empty input",
-			input:    []string{},
-			minDepth: 0,
-			expected: nil,
-		},
-		{
-			name:     "single path, depth 0",
-			input:    []string{"aaa/bbb/ccc/file.yaml"},
-			minDepth: 0,
-			expected: []string{"aaa/bbb/ccc/"},
-		},
-		{
-			name: "multiple grouped paths",
-			input: []string{
-				"file.yaml",
-				"aaa/bbb/ccc/file.yaml",
-				"aaa/bbb/ccc/bbb/file.yaml",
-				"aaa/bbb/ccc/ddd/file1.yaml",
-				"aaa/bbb/ccc/ddd/file2.yaml",
-				"aaa/bbb/ccc/ddd/eee/file.yaml",
-				"bbb/ccc/file.yaml",
-				"bbb/ccc/ddd/file.yaml",
-				"ccc/ddd/eee/fff/ggg/hhh/file.yaml",
-			},
-			minDepth: 1,
-			expected: []string{
-				"aaa/bbb/ccc/",
-				"bbb/ccc/",
-				"ccc/ddd/eee/fff/ggg/hhh/",
-			},
-		},
-		{
-			name: "no shared prefixes",
-			input: []string{
-				"aaa/file.yaml",
-				"bbb/file.yaml",
-				"ccc/file.yaml",
-			},
-			minDepth: 1,
-			expected: []string{
-				"aaa/",
-				"bbb/",
-				"ccc/",
-			},
-		},
-		{
-			name: "nested and flat mix",
-			input: []string{
-				"aaa/bbb/ccc/file.yaml",
-				"aaa/bbb/file.yaml",
-				"bbb/ccc/file.yaml",
-			},
-			minDepth: 1,
-			expected: []string{
-				"aaa/bbb/",
-				"bbb/ccc/",
-			},
-		},
-		{
-			name:     "single file in root dir",
-			input:    []string{"file.yaml"},
-			minDepth: 0,
-			expected: []string{""},
-		},
-		{
-			name: "multiple files, depth 0",
-			input: []string{
-				"file.yaml",
-				"aaa/bbb/file.yaml",
-				"ccc/file.yaml",
-			},
-			minDepth: 0,
-			expected: []string{
-				"",
-				"aaa/bbb/",
-				"ccc/",
-			},
-		},
-		{
-			name: "multiple files, depth 1",
-			input: []string{
-				"file.yaml",
-				"aaa/bbb/file.yaml",
-				"ccc/file.yaml",
-			},
-			minDepth: 1,
-			expected: []string{
-				"aaa/bbb/",
-				"ccc/",
-			},
-		},
-		{
-			name: "multiple files, depth 2",
-			input: []string{
-				"file.yaml",
-				"aaa/file.yaml",
-				"aaa/bbb/file.yaml",
-				"bbb/ccc/file.yaml",
-			},
-			minDepth: 2,
-			expected: []string{
-				"aaa/bbb/",
-				"bbb/ccc/",
-			},
-		},
-		{
-			name: "multiple files, depth 2",
-			input: []string{
-				"file.yaml",
-				"aaa/bbb/file.yaml",
-				"aaa/bbb/ccc/file.yaml",
-				"aaa/bbb/ccc/ddd/file.yaml",
-			},
-			minDepth: 3,
-			expected: []string{
-				"aaa/bbb/ccc/",
-			},
+func setStdout(t *testing.T) *strings.Builder {
+	t.Helper()
+	orig := stdout
+
+	var out strings.Builder
+	stdout = &out
+
+	t.Cleanup(func() { stdout = orig })
+	return &out
+}
+
+func TestWriteManifestsToStdout(t *testing.T) {
+	gitDir, _ := setupTest(t)
+	out := setStdout(t)
+
+	firstDeploymentPath := filepath.Join("first-project", "deployment.yaml")
+	firstDeploymentcontent := fmt.Sprintf(simpleDeploymentTemplate, "first-app")
+	secondDeploymentPath := filepath.Join("second-project", "deployment.yaml")
+	secondDeploymentcontent := fmt.Sprintf(simpleDeploymentTemplate, "second-app")
+	repoFiles := map[string]string{
+		firstDeploymentPath: firstDeploymentcontent,
+		filepath.Join("first-project", "kustomization.yaml"): simpleKustomization,
+		secondDeploymentPath: secondDeploymentcontent,
+		filepath.Join("second-project", "kustomization.yaml"): simpleKustomization,
+	}
+	buildGitRepo(t, gitDir, repoFiles)
+	expectedOut := "---\n# Source: first-project\n" + firstDeploymentcontent +
+		"---\n# Source: second-project\n" + secondDeploymentcontent
+
+	require.NoError(
+		t,
+		kustomizeBuildDirs(
+			context.Background(),
+			options{outDir: stdoutOutDir, dirDepth: mockdirDepth},
+			[]string{firstDeploymentPath, secondDeploymentPath},
+		),
+	)
+	require.Equal(t, expectedOut, out.String())
+	require.NoDirExists(t, filepath.Join(gitDir, stdoutOutDir))
+}
+
+func TestWriteManifestStream(t *testing.T) {
+	manifestMap := map[string]string{
+		"b": "kind: ConfigMap\n",
+		"a": "kind: Deployment\n---\nkind: Service\n",
+		".": "---\nkind: Namespace\n",
+	}
+	expected := `---
+# Source: .
+kind: Namespace
+---
+# Source: a
+kind: Deployment
+---
+# Source: a
+kind: Service
+---
+# Source: b
+kind: ConfigMap
+`
+
+	var out strings.Builder
+	require.NoError(t, writeManifestStream(&out, manifestMap))
+	require.Equal(t, expected, out.String())
+}
+
+func TestFailsOnInvalidWarningPattern(t *testing.T) {
+	_, err := compileWarningPatterns([]string{"deprecated", "(unclosed"})
+
+	requireErorrPrefix(t, err, "invalid '--allow-warning' pattern '(unclosed'")
+}
+
+func TestParseSize(t *testing.T) {
+	for value, expected := range map[string]int64{
+		"":      0,
+		"1000":  1000,
+		"1Mi":   1024 * 1024,
+		"1.5Ki": 1536,
+		"10M":   10_000_000,
+	} {
+		size, err := parseSize("max-config-size", value)
+		require.NoError(t, err)
+		require.Equal(t, expected, size, value)
+	}
+
+	_, err := parseSize("max-config-size", "lots")
+	requireErorrPrefix(t, err, "invalid '--max-config-size' size 'lots'")
+}
+
+func TestFailsOnExceedingLimits(t *testing.T) {
+	gitDir, outDir := setupTest(t)
+
+	deploymentPath := filepath.Join("manifests", "deployment.yaml")
+	repoFiles := map[string]string{
+		filepath.Join("manifests", "kustomization.yaml"): simpleKustomization,
+		deploymentPath: simpleDeployment,
+	}
+	buildGitRepo(t, gitDir, repoFiles)
+
+	err := kustomizeBuildDirs(
+		context.Background(),
+		options{
+			outDir:   outDir,
+			dirDepth: mockdirDepth,
+			limits:   kustomizebuild.Limits{MaxBytes: 10},
 		},
+		[]string{deploymentPath},
+	)
+	requireErorrPrefix(t, err, "manifests built from manifests exceed limits:")
+}
+
+func TestFailsOnDuplicateObjectsInCluster(t *testing.T) {
+	gitDir, outDir := setupTest(t)
+
+	firstDeploymentPath := filepath.Join("cluster", "first-project", "deployment.yaml")
+	secondDeploymentPath := filepath.Join("cluster", "second-project", "deployment.yaml")
+	repoFiles := map[string]string{
+		firstDeploymentPath: simpleDeployment,
+		filepath.Join("cluster", "first-project", "kustomization.yaml"): simpleKustomization,
+		secondDeploymentPath: simpleDeployment,
+		filepath.Join("cluster", "second-project", "kustomization.yaml"): simpleKustomization,
+	}
+	buildGitRepo(t, gitDir, repoFiles)
+	expectedErr := "found objects built from multiple kustomizations:\n" +
+		"\tapps/Deployment my-cool-app in cluster cluster built from: " +
+		"cluster/first-project, cluster/second-project"
+
+	err := kustomizeBuildDirs(
+		context.Background(),
+		options{outDir: outDir, dirDepth: mockdirDepth, clusterDepth: 1},
+		[]string{firstDeploymentPath, secondDeploymentPath},
+	)
+	require.EqualError(t, err, expectedErr)
+	require.NoFileExists(t, outDir)
+}
+
+func commitAll(t *testing.T, gitDir string) {
+	t.Helper()
+
+	runGitCmd(t, gitDir, []string{"add", "."})
+	runGitCmd(t, gitDir, []string{
+		"-c", "user.name=test",
+		"-c", "user.email=test@example.com",
+		"commit", "--message", "commit",
+	})
+}
+
+func TestBuildsFromRef(t *testing.T) {
+	gitDir, outDir := setupTest(t)
+
+	manifestPath := filepath.Join("manifests", "deployment.yaml")
+	committedDeployment := fmt.Sprintf(simpleDeploymentTemplate, "committed-app")
+	repoFiles := map[string]string{
+		filepath.Join("manifests", "kustomization.yaml"): simpleKustomization,
+		manifestPath: committedDeployment,
+	}
+	buildGitRepo(t, gitDir, repoFiles)
+	commitAll(t, gitDir)
+	runGitCmd(t, gitDir, []string{"tag", "v1.0.0"})
+	// change the working tree, which shouldn't be built
+	uncommittedDeployment := fmt.Sprintf(simpleDeploymentTemplate, "uncommitted-app")
+	require.NoError(
+		t,
+		os.WriteFile(filepath.Join(gitDir, manifestPath), []byte(uncommittedDeployment), 0o600),
+	)
+	expectedContents := map[string]string{
+		"manifests": committedDeployment,
+	}
+
+	require.NoError(
+		t,
+		kustomizeBuildDirs(
+			context.Background(),
+			options{outDir: outDir, dirDepth: mockdirDepth, ref: "v1.0.0"},
+			[]string{manifestPath},
+		),
+	)
+	compareResults(t, outDir, expectedContents, readOutDir(t, outDir))
+
+	workingTreeDeployment, err := os.ReadFile(filepath.Join(gitDir, manifestPath))
+	require.NoError(t, err)
+	require.Equal(t, uncommittedDeployment, string(workingTreeDeployment))
+
+	var worktrees strings.Builder
+	cmd := exec.Command("git", "-C", gitDir, "worktree", "list", "--porcelain")
+	cmd.Stdout = &worktrees
+	require.NoError(t, cmd.Run())
+	require.Equal(t, 1, strings.Count(worktrees.String(), "worktree "), "worktree not removed")
+}
+
+func TestRemovesWorktreeWhenCancelled(t *testing.T) {
+	gitDir, outDir := setupTest(t)
+
+	manifestPath := filepath.Join("manifests", "deployment.yaml")
+	repoFiles := map[string]string{
+		filepath.Join("manifests", "kustomization.yaml"): simpleKustomization,
+		manifestPath: fmt.Sprintf(simpleDeploymentTemplate, "committed-app"),
 	}
+	buildGitRepo(t, gitDir, repoFiles)
+	commitAll(t, gitDir)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := kustomizeBuildDirs(
+		ctx,
+		options{outDir: outDir, dirDepth: mockdirDepth, ref: "HEAD"},
+		[]string{manifestPath},
+	)
+	require.ErrorContains(t, err, "context canceled")
+
+	var worktrees strings.Builder
+	cmd := exec.Command("git", "-C", gitDir, "worktree", "list", "--porcelain")
+	cmd.Stdout = &worktrees
+	require.NoError(t, cmd.Run())
+	require.Equal(t, 1, strings.Count(worktrees.String(), "worktree "), "worktree not removed")
+}
+
+func TestFailsOnUnknownRef(t *testing.T) {
+	gitDir, outDir := setupTest(t)
+
+	repoFiles := map[string]string{
+		"kustomization.yaml": simpleKustomization,
+	}
+	buildGitRepo(t, gitDir, repoFiles)
+	commitAll(t, gitDir)
+	expectedErrPrefix := "Error checking out 'not-a-ref' via 'git -C " + gitDir + " worktree add"
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := deepestCommonDirs(tt.input, tt.minDepth)
-			require.Equal(t, tt.expected, got)
-		})
+	err := kustomizeBuildDirs(
+		context.Background(),
+		options{outDir: outDir, dirDepth: mockdirDepth, ref: "not-a-ref"},
+		[]string{"kustomization.yaml"},
+	)
+	requireErorrPrefix(t, err, expectedErrPrefix)
+}
+
+func TestBuildsFromNearestMarkedRoot(t *testing.T) {
+	gitDir, outDir := setupTest(t)
+
+	rootMarker := ".deployable"
+	aggregatedDeploymentPath := filepath.Join("marked", "aggregated", "deployment.yaml")
+	unmarkedDeploymentPath := filepath.Join("unmarked", "deployment.yaml")
+	markedKustomization := `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+
+resources:
+  - aggregated/deployment.yaml
+`
+	repoFiles := map[string]string{
+		filepath.Join("marked", "kustomization.yaml"):               markedKustomization,
+		filepath.Join("marked", rootMarker):                         "",
+		filepath.Join("marked", "aggregated", "kustomization.yaml"): simpleKustomization,
+		aggregatedDeploymentPath:                                    simpleDeployment,
+		filepath.Join("unmarked", "kustomization.yaml"):             simpleKustomization,
+		unmarkedDeploymentPath:                                      simpleDeployment,
 	}
+	buildGitRepo(t, gitDir, repoFiles)
+	expectedContents := map[string]string{
+		"marked": simpleDeployment,
+	}
+
+	require.NoError(
+		t,
+		kustomizeBuildDirs(
+			context.Background(),
+			options{outDir: outDir, dirDepth: mockdirDepth, rootMarker: rootMarker},
+			[]string{aggregatedDeploymentPath, unmarkedDeploymentPath},
+		),
+	)
+	got := readOutDir(t, outDir)
+	compareResults(t, outDir, expectedContents, got)
+	require.Len(t, got, len(expectedContents))
 }
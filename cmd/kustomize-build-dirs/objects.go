@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/utilitywarehouse/manifest-checkers/kustomizebuild"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// sortedRoots returns the kustomization roots of the built manifests in order
+func sortedRoots(manifestMap map[string]string) []string {
+	roots := make([]string, 0, len(manifestMap))
+	for root := range manifestMap {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// decodeObjects decodes every object in a multi-document manifest, skipping
+// empty documents
+func decodeObjects(manifest string) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	for _, document := range kustomizebuild.SplitDocuments(manifest) {
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(document), &obj.Object); err != nil {
+			return nil, err
+		}
+		if obj.Object != nil {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+// container is a container found in an object
+type container struct {
+	// field is the pod spec field holding the container, e.g. 'initContainers'
+	field string
+	name  string
+	image string
+}
+
+// findContainers returns every container in obj. Rather than looking in the
+// pod spec of known kinds, it finds the containers in any pod spec in the
+// object, so containers of custom resources embedding a pod spec are found too
+func findContainers(obj map[string]interface{}) []container {
+	var containers []container
+	for key, value := range obj {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			containers = append(containers, findContainers(value)...)
+		case []interface{}:
+			for _, item := range value {
+				itemMap, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				image, hasImage := itemMap["image"].(string)
+				if hasImage && isContainerField(key) {
+					name, _ := itemMap["name"].(string)
+					containers = append(containers, container{field: key, name: name, image: image})
+					continue
+				}
+				containers = append(containers, findContainers(itemMap)...)
+			}
+		}
+	}
+	return containers
+}
+
+func isContainerField(field string) bool {
+	return field == "containers" || field == "initContainers" || field == "ephemeralContainers"
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/utilitywarehouse/manifest-checkers/kustomizebuild"
+)
+
+// maxReportedErrorLines is the number of lines of an error kept in reports.
+// The end of `kustomize build` output is usually the most relevant
+const maxReportedErrorLines = 20
+
+// runReport records the outcome for each directory considered in a run, so it
+// can be summarised afterwards. It is safe for concurrent use
+type runReport struct {
+	mutex sync.Mutex
+	// built maps kustomization roots built to the number of objects built
+	built map[string]int
+	// skipped maps directories that weren't built to the reason why
+	skipped  map[string]string
+	failed   map[string]string
+	warnings map[string][]string
+}
+
+func newRunReport() *runReport {
+	return &runReport{
+		built:    map[string]int{},
+		skipped:  map[string]string{},
+		failed:   map[string]string{},
+		warnings: map[string][]string{},
+	}
+}
+
+func (r *runReport) addBuilt(root string, manifest string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.built[root] = len(kustomizebuild.SplitDocuments(manifest))
+}
+
+func (r *runReport) addSkipped(dir string, reason string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.skipped[dir] = reason
+}
+
+func (r *runReport) addFailed(root string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.failed[root] = err.Error()
+}
+
+func (r *runReport) addWarnings(root string, warnings []string) {
+	if len(warnings) == 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.warnings[root] = append(r.warnings[root], warnings...)
+}
+
+// addResult records the outcome of building kustomizations with the given
+// minimum depth
+func (r *runReport) addResult(result *kustomizebuild.Result, minDepth int) {
+	for _, dir := range result.Shallow {
+		r.addSkipped(dir, fmt.Sprintf("shallower than `--depth %d`", minDepth))
+	}
+	for _, root := range result.Components {
+		r.addSkipped(root, "Component")
+	}
+	for root, err := range result.Failed {
+		r.addFailed(root, err)
+	}
+	for root, warnings := range result.Warnings {
+		r.addWarnings(root, warnings)
+	}
+	for root, manifest := range result.Manifests {
+		r.addBuilt(root, manifest)
+	}
+}
+
+// markdown renders the report as markdown, with each section collapsed. runErr
+// is the error the run failed with, if any, and is included when it isn't
+// already reported as a failure of a kustomization
+func (r *runReport) markdown(runErr error) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var md strings.Builder
+	md.WriteString("## kustomize-build-dirs\n\n")
+
+	totalObjects := 0
+	for _, objects := range r.built {
+		totalObjects += objects
+	}
+	fmt.Fprintf(
+		&md,
+		"Built %d kustomizations (%d objects), skipped %d, %d failed\n",
+		len(r.built),
+		totalObjects,
+		len(r.skipped),
+		len(r.failed),
+	)
+
+	isReported := false
+	for _, failure := range r.failed {
+		isReported = isReported || (runErr != nil && runErr.Error() == failure)
+	}
+	if runErr != nil && !isReported {
+		fmt.Fprintf(&md, "\n**Error:**\n\n```\n%s\n```\n", trimLines(runErr.Error()))
+	}
+
+	if len(r.failed) != 0 {
+		startSection(&md, "Failed", len(r.failed), true)
+		for _, root := range sortedKeys(r.failed) {
+			fmt.Fprintf(&md, "#### `%s`\n\n```\n%s\n```\n\n", root, trimLines(r.failed[root]))
+		}
+		md.WriteString("</details>\n")
+	}
+
+	if len(r.built) != 0 {
+		startSection(&md, "Built", len(r.built), false)
+		md.WriteString("| Kustomization | Objects | Warnings |\n| --- | --- | --- |\n")
+		for _, root := range sortedKeys(r.built) {
+			fmt.Fprintf(
+				&md,
+				"| `%s` | %d | %d |\n",
+				root,
+				r.built[root],
+				len(r.warnings[root]),
+			)
+		}
+		md.WriteString("\n</details>\n")
+	}
+
+	if len(r.skipped) != 0 {
+		startSection(&md, "Skipped", len(r.skipped), false)
+		md.WriteString("| Directory | Reason |\n| --- | --- |\n")
+		for _, dir := range sortedKeys(r.skipped) {
+			fmt.Fprintf(&md, "| `%s` | %s |\n", dir, r.skipped[dir])
+		}
+		md.WriteString("\n</details>\n")
+	}
+
+	if len(r.warnings) != 0 {
+		total := 0
+		for _, warnings := range r.warnings {
+			total += len(warnings)
+		}
+		startSection(&md, "Warnings", total, false)
+		md.WriteString("| Kustomization | Warning |\n| --- | --- |\n")
+		for _, root := range sortedKeys(r.warnings) {
+			for _, warning := range r.warnings[root] {
+				fmt.Fprintf(
+					&md,
+					"| `%s` | %s |\n",
+					root,
+					strings.ReplaceAll(warning, "|", "\\|"),
+				)
+			}
+		}
+		md.WriteString("\n</details>\n")
+	}
+
+	return md.String()
+}
+
+// writeMarkdown writes the report as markdown to target
+func (r *runReport) writeMarkdown(target string, runErr error) error {
+	if err := os.WriteFile(target, []byte(r.markdown(runErr)), 0o600); err != nil {
+		return fmt.Errorf("error writing to '%s': %v", target, err)
+	}
+	return nil
+}
+
+func startSection(md *strings.Builder, title string, count int, open bool) {
+	details := "<details>"
+	if open {
+		details = "<details open>"
+	}
+	fmt.Fprintf(md, "\n%s\n<summary>%s (%d)</summary>\n\n", details, title, count)
+}
+
+// trimLines trims s to its last maxReportedErrorLines lines
+func trimLines(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) <= maxReportedErrorLines {
+		return strings.Join(lines, "\n")
+	}
+	trimmed := lines[len(lines)-maxReportedErrorLines:]
+	return fmt.Sprintf(
+		"... (%d lines trimmed)\n%s",
+		len(lines)-maxReportedErrorLines,
+		strings.Join(trimmed, "\n"),
+	)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunReportMarkdown(t *testing.T) {
+	report := newRunReport()
+	report.addBuilt("first-project", simpleDeployment+"---\n"+simpleDeployment)
+	report.addBuilt("second-project", simpleDeployment)
+	report.addSkipped("components/sidecar", "Component")
+	report.addWarnings("second-project", []string{"# Warning: 'bases' is deprecated | use 'resources'"})
+	buildErr := errors.New("Error running 'kustomize build bad-project': exit status 1")
+	report.addFailed("bad-project", buildErr)
+	expected := "## kustomize-build-dirs\n" +
+		"\n" +
+		"Built 2 kustomizations (3 objects), skipped 1, 1 failed\n" +
+		"\n" +
+		"<details open>\n<summary>Failed (1)</summary>\n" +
+		"\n" +
+		"#### `bad-project`\n" +
+		"\n" +
+		"```\nError running 'kustomize build bad-project': exit status 1\n```\n" +
+		"\n" +
+		"</details>\n" +
+		"\n" +
+		"<details>\n<summary>Built (2)</summary>\n" +
+		"\n" +
+		"| Kustomization | Objects | Warnings |\n" +
+		"| --- | --- | --- |\n" +
+		"| `first-project` | 2 | 0 |\n" +
+		"| `second-project` | 1 | 1 |\n" +
+		"\n" +
+		"</details>\n" +
+		"\n" +
+		"<details>\n<summary>Skipped (1)</summary>\n" +
+		"\n" +
+		"| Directory | Reason |\n" +
+		"| --- | --- |\n" +
+		"| `components/sidecar` | Component |\n" +
+		"\n" +
+		"</details>\n" +
+		"\n" +
+		"<details>\n<summary>Warnings (1)</summary>\n" +
+		"\n" +
+		"| Kustomization | Warning |\n" +
+		"| --- | --- |\n" +
+		"| `second-project` | # Warning: 'bases' is deprecated \\| use 'resources' |\n" +
+		"\n" +
+		"</details>\n"
+
+	require.Equal(t, expected, report.markdown(buildErr))
+}
+
+func TestRunReportMarkdownIncludesRunError(t *testing.T) {
+	report := newRunReport()
+	report.addBuilt("first-project", simpleDeployment)
+	expected := "## kustomize-build-dirs\n" +
+		"\n" +
+		"Built 1 kustomizations (1 objects), skipped 0, 0 failed\n" +
+		"\n" +
+		"**Error:**\n" +
+		"\n" +
+		"```\nfound objects built from multiple kustomizations\n```\n"
+
+	got := report.markdown(errors.New("found objects built from multiple kustomizations"))
+	require.Equal(t, expected, got[:len(expected)])
+}
+
+func TestTrimLines(t *testing.T) {
+	var lines []string
+	for i := 1; i <= maxReportedErrorLines+5; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+
+	trimmed := trimLines(strings.Join(lines, "\n") + "\n")
+
+	require.Equal(
+		t,
+		"... (5 lines trimmed)\n"+strings.Join(lines[5:], "\n"),
+		trimmed,
+	)
+	require.Equal(t, "line 1\nline 2", trimLines("line 1\nline 2\n"))
+}
+
+func TestWritesMarkdownSummary(t *testing.T) {
+	gitDir, outDir := setupTest(t)
+	summaryFile := filepath.Join(t.TempDir(), "summary.md")
+
+	manifestPath := filepath.Join("project", "manifests", "deployment.yaml")
+	componentPath := filepath.Join("project", "component", "deployment.yaml")
+	badPath := filepath.Join("project", "bad", "kustomization.yaml")
+	repoFiles := map[string]string{
+		filepath.Join("project", "manifests", "kustomization.yaml"): simpleKustomization,
+		manifestPath: simpleDeployment,
+		filepath.Join("project", "component", "kustomization.yaml"): componentKustomization,
+		componentPath: simpleDeployment,
+		badPath:       "apiVersion: some.other.api/v1\nkind: Kustomization\n",
+		"README.md":   "# Readme\n",
+	}
+	buildGitRepo(t, gitDir, repoFiles)
+
+	err := kustomizeBuildDirs(
+		context.Background(),
+		options{outDir: outDir, dirDepth: 2, summaryMarkdown: summaryFile},
+		[]string{manifestPath, componentPath, badPath, "README.md"},
+	)
+	require.Error(t, err)
+
+	summary, err := os.ReadFile(summaryFile)
+	require.NoError(t, err)
+	require.Contains(t, string(summary), "Built 1 kustomizations (1 objects), skipped 2, 1 failed\n")
+	require.Contains(t, string(summary), "#### `project/bad`\n\n```\nError running 'kustomize build ")
+	require.Contains(t, string(summary), "| `project/manifests` | 1 | 0 |\n")
+	require.Contains(t, string(summary), "| `.` | shallower than `--depth 2` |\n")
+	require.Contains(t, string(summary), "| `project/component` | Component |\n")
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/utilitywarehouse/manifest-checkers/kustomizebuild"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/applyconfigurations"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+const gvkExtension = "x-kubernetes-group-version-kind"
+
+// typeSchemas holds the OpenAPI schemas for a set of kinds
+type typeSchemas struct {
+	converter managedfields.TypeConverter
+	kinds     map[schema.GroupVersionKind]struct{}
+}
+
+func (s typeSchemas) has(gvk schema.GroupVersionKind) bool {
+	_, ok := s.kinds[gvk]
+	return ok
+}
+
+// newTypeSchemas creates typeSchemas from OpenAPI definitions, each kind being
+// identified by the 'x-kubernetes-group-version-kind' extension of its
+// definition
+func newTypeSchemas(definitions map[string]*spec.Schema) (typeSchemas, error) {
+	converter, err := managedfields.NewTypeConverter(definitions, false)
+	if err != nil {
+		return typeSchemas{}, err
+	}
+
+	kinds := map[schema.GroupVersionKind]struct{}{}
+	for _, definition := range definitions {
+		gvks, _ := definition.Extensions[gvkExtension].([]interface{})
+		for _, gvk := range gvks {
+			gvkMap, _ := gvk.(map[string]interface{})
+			group, _ := gvkMap["group"].(string)
+			version, _ := gvkMap["version"].(string)
+			kind, _ := gvkMap["kind"].(string)
+			if kind != "" {
+				kinds[schema.GroupVersionKind{Group: group, Version: version, Kind: kind}] = struct{}{}
+			}
+		}
+	}
+
+	return typeSchemas{converter: converter, kinds: kinds}, nil
+}
+
+// loadBuiltinSchemas returns the schemas for builtin Kubernetes kinds. These are
+// read from schemaFile, the OpenAPI v2 spec ('swagger.json') of a Kubernetes
+// version, when given, otherwise the schemas bundled with client-go are used,
+// which are only those of the Kubernetes version client-go is for
+func loadBuiltinSchemas(schemaFile string) (typeSchemas, error) {
+	if schemaFile == "" {
+		kinds := map[schema.GroupVersionKind]struct{}{}
+		for gvk := range scheme.Scheme.AllKnownTypes() {
+			kinds[gvk] = struct{}{}
+		}
+		return typeSchemas{
+			converter: applyconfigurations.NewTypeConverter(scheme.Scheme),
+			kinds:     kinds,
+		}, nil
+	}
+
+	data, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return typeSchemas{}, fmt.Errorf("error reading schema file '%s': %v", schemaFile, err)
+	}
+	var openAPISpec struct {
+		Definitions map[string]*spec.Schema `json:"definitions"`
+	}
+	if err := json.Unmarshal(data, &openAPISpec); err != nil {
+		return typeSchemas{}, fmt.Errorf("error parsing schema file '%s': %v", schemaFile, err)
+	}
+
+	schemas, err := newTypeSchemas(openAPISpec.Definitions)
+	if err != nil {
+		return typeSchemas{}, fmt.Errorf("error loading schemas from '%s': %v", schemaFile, err)
+	}
+	return schemas, nil
+}
+
+// customResourceDefinition represents the parts of a CustomResourceDefinition
+// needed to validate custom resources
+type customResourceDefinition struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Group string `json:"group"`
+		Names struct {
+			Kind string `json:"kind"`
+		} `json:"names"`
+		Versions []struct {
+			Name   string `json:"name"`
+			Schema struct {
+				OpenAPIV3Schema *spec.Schema `json:"openAPIV3Schema"`
+			} `json:"schema"`
+		} `json:"versions"`
+	} `json:"spec"`
+}
+
+// addCRDSchemas adds the schema of every version of each
+// CustomResourceDefinition in manifest to definitions
+func addCRDSchemas(definitions map[string]*spec.Schema, manifest string) {
+	for _, document := range kustomizebuild.SplitDocuments(manifest) {
+		var crd customResourceDefinition
+		if err := yaml.Unmarshal([]byte(document), &crd); err != nil {
+			// not every YAML file in a repo is a manifest
+			continue
+		}
+		if crd.APIVersion != "apiextensions.k8s.io/v1" || crd.Kind != "CustomResourceDefinition" {
+			continue
+		}
+
+		for _, version := range crd.Spec.Versions {
+			definition := version.Schema.OpenAPIV3Schema
+			if definition == nil {
+				continue
+			}
+			// the API server implicitly adds these, and they're
+			// usually left out of CRD schemas
+			for name, property := range map[string]*spec.Schema{
+				"apiVersion": spec.StringProperty(),
+				"kind":       spec.StringProperty(),
+				"metadata":   new(spec.Schema).Typed("object", ""),
+			} {
+				if _, ok := definition.Properties[name]; !ok {
+					definition.SetProperty(name, *property)
+				}
+			}
+			definition.AddExtension(gvkExtension, []interface{}{
+				map[string]interface{}{
+					"group":   crd.Spec.Group,
+					"version": version.Name,
+					"kind":    crd.Spec.Names.Kind,
+				},
+			})
+			name := fmt.Sprintf("%s.%s.%s", crd.Spec.Group, version.Name, crd.Spec.Names.Kind)
+			definitions[name] = definition
+		}
+	}
+}
+
+// findCRDSchemas returns the schemas of all CustomResourceDefinitions found in
+// YAML files under rootDir and in the built manifests. Directories and files
+// that can't be read are skipped with a warning, since most won't hold any
+func findCRDSchemas(
+	rootDir string,
+	manifestMap map[string]string,
+) (map[string]*spec.Schema, error) {
+	definitions := map[string]*spec.Schema{}
+
+	walkFunc := func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == rootDir {
+				return err
+			}
+			slog.Warn("skipping path when searching for CustomResourceDefinitions", "path", path, "error", err)
+			return nil
+		}
+		if entry.IsDir() {
+			// skip '.git' and the like
+			if path != rootDir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("skipping path when searching for CustomResourceDefinitions", "path", path, "error", err)
+			return nil
+		}
+		if strings.Contains(string(data), "CustomResourceDefinition") {
+			addCRDSchemas(definitions, string(data))
+		}
+		return nil
+	}
+	if err := filepath.WalkDir(rootDir, walkFunc); err != nil {
+		return nil, fmt.Errorf("error searching for CustomResourceDefinitions: %v", err)
+	}
+
+	// built CRDs take precedence, since they may have been patched
+	for _, manifest := range manifestMap {
+		addCRDSchemas(definitions, manifest)
+	}
+	return definitions, nil
+}
+
+// validateSchemas validates every object in the built manifests against the
+// OpenAPI schema of its kind, either a builtin Kubernetes kind or one defined
+// by a CustomResourceDefinition in the repo. Objects of other kinds are
+// skipped
+func validateSchemas(rootDir string, schemaFile string, manifestMap map[string]string) error {
+	builtin, err := loadBuiltinSchemas(schemaFile)
+	if err != nil {
+		return err
+	}
+
+	crdDefinitions, err := findCRDSchemas(rootDir, manifestMap)
+	if err != nil {
+		return err
+	}
+	crds, err := newTypeSchemas(crdDefinitions)
+	if err != nil {
+		return fmt.Errorf("error loading CustomResourceDefinition schemas: %v", err)
+	}
+
+	var failures []string
+	for _, root := range sortedRoots(manifestMap) {
+		objects, err := decodeObjects(manifestMap[root])
+		if err != nil {
+			return fmt.Errorf("error parsing manifests built from %s: %v", root, err)
+		}
+		for _, obj := range objects {
+			gvk := obj.GroupVersionKind()
+			var schemas typeSchemas
+			switch {
+			case crds.has(gvk):
+				schemas = crds
+			case builtin.has(gvk):
+				schemas = builtin
+			default:
+				slog.Debug(
+					"no schema found",
+					"root", root,
+					"apiVersion", obj.GetAPIVersion(),
+					"kind", gvk.Kind,
+				)
+				continue
+			}
+
+			if _, err := schemas.converter.ObjectToTyped(obj); err != nil {
+				failures = append(
+					failures,
+					fmt.Sprintf(
+						"%s: invalid %s: %s:\n\t\t%s",
+						root,
+						gvk.Kind,
+						obj.GetName(),
+						strings.Join(schemaErrors(err), "\n\t\t"),
+					),
+				)
+			}
+		}
+	}
+
+	if len(failures) != 0 {
+		return fmt.Errorf("manifests failed schema validation:\n\t%s", strings.Join(failures, "\n\t"))
+	}
+	return nil
+}
+
+// schemaErrors splits a validation error, which may hold multiple errors one
+// per line under an 'errors:' heading, into the individual errors
+func schemaErrors(err error) []string {
+	var errs []string
+	for _, line := range strings.Split(err.Error(), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && line != "errors:" {
+			errs = append(errs, line)
+		}
+	}
+	// sort for a consistent output
+	sort.Strings(errs)
+	return errs
+}
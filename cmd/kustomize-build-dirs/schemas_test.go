@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	invalidDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: bad-deployment
+spec:
+  replica: 3
+  template:
+    spec:
+      containers:
+        - name: app
+          image: app:latest
+`
+	validDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: good-deployment
+  annotations:
+    app.uw.systems/tier: tier_4
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+        - name: app
+          image: app:latest
+`
+	widgetCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+    plural: widgets
+  scope: Namespaced
+  versions:
+    - name: v1
+      served: true
+      storage: true
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              properties:
+                size:
+                  type: integer
+`
+	widgetTemplate = `apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: my-widget
+spec:
+  %s
+`
+	unknownKind = `apiVersion: example.com/v1
+kind: Gadget
+metadata:
+  name: my-gadget
+spec:
+  anything: goes
+`
+)
+
+func TestValidateSchemasAcceptsValidManifests(t *testing.T) {
+	rootDir := t.TempDir()
+	require.NoError(
+		t,
+		os.WriteFile(filepath.Join(rootDir, "crd.yaml"), []byte(widgetCRD), 0o600),
+	)
+	manifestMap := map[string]string{
+		"deployment": validDeployment,
+		"widget":     fmt.Sprintf(widgetTemplate, "size: 3"),
+		"unknown":    unknownKind,
+	}
+
+	require.NoError(t, validateSchemas(rootDir, "", manifestMap))
+}
+
+func TestValidateSchemasRejectsInvalidManifests(t *testing.T) {
+	rootDir := t.TempDir()
+	require.NoError(
+		t,
+		os.WriteFile(filepath.Join(rootDir, "crd.yaml"), []byte(widgetCRD), 0o600),
+	)
+	manifestMap := map[string]string{
+		"deployment": invalidDeployment,
+		"widget":     fmt.Sprintf(widgetTemplate, "colour: blue"),
+	}
+	expectedErr := `manifests failed schema validation:
+	deployment: invalid Deployment: bad-deployment:
+		.spec.replica: field not declared in schema
+	widget: invalid Widget: my-widget:
+		.spec.colour: field not declared in schema`
+
+	require.EqualError(t, validateSchemas(rootDir, "", manifestMap), expectedErr)
+}
+
+func TestValidateSchemasUsesBuiltCRDs(t *testing.T) {
+	manifestMap := map[string]string{
+		"crd":    widgetCRD,
+		"widget": fmt.Sprintf(widgetTemplate, "size: large"),
+	}
+	expectedErr := `manifests failed schema validation:
+	widget: invalid Widget: my-widget:
+		.spec.size: expected numeric (int or float), got string`
+
+	require.EqualError(t, validateSchemas(t.TempDir(), "", manifestMap), expectedErr)
+}
+
+func TestValidateSchemasSkipsUnreadableDirectories(t *testing.T) {
+	rootDir := t.TempDir()
+	unreadableDir := filepath.Join(rootDir, "unreadable")
+	require.NoError(t, os.Mkdir(unreadableDir, 0o700))
+	require.NoError(
+		t,
+		os.WriteFile(filepath.Join(rootDir, "crd.yaml"), []byte(widgetCRD), 0o600),
+	)
+	require.NoError(t, os.Chmod(unreadableDir, 0o200))
+	t.Cleanup(func() { _ = os.Chmod(unreadableDir, 0o700) })
+	manifestMap := map[string]string{
+		"widget": fmt.Sprintf(widgetTemplate, "colour: blue"),
+	}
+	expectedErr := `manifests failed schema validation:
+	widget: invalid Widget: my-widget:
+		.spec.colour: field not declared in schema`
+
+	require.EqualError(t, validateSchemas(rootDir, "", manifestMap), expectedErr)
+}
+
+func TestValidateSchemasUsesSchemaFile(t *testing.T) {
+	schemaFile := filepath.Join(t.TempDir(), "swagger.json")
+	swagger := `{
+  "definitions": {
+    "io.k8s.api.core.v1.ConfigMap": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"type": "object"},
+        "data": {"type": "object", "additionalProperties": {"type": "string"}}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "", "kind": "ConfigMap", "version": "v1"}]
+    }
+  }
+}`
+	require.NoError(t, os.WriteFile(schemaFile, []byte(swagger), 0o600))
+	manifestMap := map[string]string{
+		"config": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\nbinaryData: {}\n",
+		// not in the schema file, so not validated
+		"deployment": invalidDeployment,
+	}
+	expectedErr := `manifests failed schema validation:
+	config: invalid ConfigMap: config:
+		.binaryData: field not declared in schema`
+
+	require.EqualError(t, validateSchemas(t.TempDir(), schemaFile, manifestMap), expectedErr)
+}
+
+func TestValidateSchemasFailsOnMissingSchemaFile(t *testing.T) {
+	schemaFile := filepath.Join(t.TempDir(), "swagger.json")
+
+	err := validateSchemas(t.TempDir(), schemaFile, map[string]string{})
+
+	requireErorrPrefix(t, err, "error reading schema file '"+schemaFile+"'")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	summaryFileName          = "summary.json"
+	aggregateSummaryFileName = "aggregate-summary.json"
+)
+
+// summary describes a set of built objects
+type summary struct {
+	Objects int `json:"objects"`
+	// Kinds counts objects by kind, qualified by API group for kinds outside
+	// the core group, e.g. 'Deployment.apps'
+	Kinds      map[string]int `json:"kinds"`
+	Namespaces []string       `json:"namespaces"`
+	Images     []string       `json:"images"`
+}
+
+// aggregateSummary describes all objects built in a run
+type aggregateSummary struct {
+	Roots []string `json:"roots"`
+	summary
+}
+
+func newSummary(objects []*unstructured.Unstructured) summary {
+	s := summary{
+		Objects:    len(objects),
+		Kinds:      map[string]int{},
+		Namespaces: []string{},
+		Images:     []string{},
+	}
+	namespaces := map[string]struct{}{}
+	images := map[string]struct{}{}
+
+	for _, obj := range objects {
+		kind := obj.GetKind()
+		if group := obj.GroupVersionKind().Group; group != "" {
+			kind += "." + group
+		}
+		s.Kinds[kind]++
+
+		if namespace := obj.GetNamespace(); namespace != "" {
+			namespaces[namespace] = struct{}{}
+		}
+		for _, c := range findContainers(obj.Object) {
+			images[c.image] = struct{}{}
+		}
+	}
+
+	for namespace := range namespaces {
+		s.Namespaces = append(s.Namespaces, namespace)
+	}
+	sort.Strings(s.Namespaces)
+	for image := range images {
+		s.Images = append(s.Images, image)
+	}
+	sort.Strings(s.Images)
+
+	return s
+}
+
+// writeSummaries writes a summary of the objects built from each
+// kustomization root next to its manifests, and a summary of all objects
+// built to the root of outDir
+func writeSummaries(outDir string, manifestMap map[string]string) error {
+	roots := sortedRoots(manifestMap)
+	var allObjects []*unstructured.Unstructured
+	for _, root := range roots {
+		objects, err := decodeObjects(manifestMap[root])
+		if err != nil {
+			return fmt.Errorf("error parsing manifests built from %s: %v", root, err)
+		}
+		allObjects = append(allObjects, objects...)
+
+		target := filepath.Join(outDir, root, summaryFileName)
+		if err := writeJSON(target, newSummary(objects)); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(outDir, 0o700); err != nil {
+		return fmt.Errorf("failed creating target directory '%s': %v", outDir, err)
+	}
+	aggregate := aggregateSummary{Roots: roots, summary: newSummary(allObjects)}
+	return writeJSON(filepath.Join(outDir, aggregateSummaryFileName), aggregate)
+}
+
+func writeJSON(target string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil { //go-cov:skip
+		return fmt.Errorf("error encoding '%s': %v", target, err)
+	}
+	if err := os.WriteFile(target, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("error writing to '%s': %v", target, err)
+	}
+	return nil
+}
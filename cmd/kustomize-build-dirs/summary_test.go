@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const summaryManifest = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: my-ns
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: my-ns
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: init
+          image: busybox:1.36
+      containers:
+        - name: app
+          image: registry.example.com/app:v1
+        - name: sidecar
+          image: registry.example.com/sidecar@sha256:0123
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: job
+  namespace: other-ns
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: job
+              image: registry.example.com/app:v1
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: rollout
+  namespace: my-ns
+spec:
+  template:
+    spec:
+      containers:
+        - name: rollout
+          image: registry.example.com/rollout:v2
+`
+
+func TestNewSummary(t *testing.T) {
+	objects, err := decodeObjects(summaryManifest)
+	require.NoError(t, err)
+	expected := summary{
+		Objects: 4,
+		Kinds: map[string]int{
+			"Namespace":           1,
+			"Deployment.apps":     1,
+			"CronJob.batch":       1,
+			"Rollout.argoproj.io": 1,
+		},
+		Namespaces: []string{"my-ns", "other-ns"},
+		Images: []string{
+			"busybox:1.36",
+			"registry.example.com/app:v1",
+			"registry.example.com/rollout:v2",
+			"registry.example.com/sidecar@sha256:0123",
+		},
+	}
+
+	require.Equal(t, expected, newSummary(objects))
+}
+
+func TestWritesSummaries(t *testing.T) {
+	gitDir, outDir := setupTest(t)
+
+	firstDeploymentPath := filepath.Join("first-project", "deployment.yaml")
+	secondDeploymentPath := filepath.Join("second-project", "deployment.yaml")
+	repoFiles := map[string]string{
+		firstDeploymentPath: fmt.Sprintf(simpleDeploymentTemplate, "first-app"),
+		filepath.Join("first-project", "kustomization.yaml"): simpleKustomization,
+		secondDeploymentPath: fmt.Sprintf(simpleDeploymentTemplate, "second-app"),
+		filepath.Join("second-project", "kustomization.yaml"): simpleKustomization,
+	}
+	buildGitRepo(t, gitDir, repoFiles)
+	expectedRootSummary := `{
+  "objects": 1,
+  "kinds": {
+    "Deployment.apps": 1
+  },
+  "namespaces": [],
+  "images": []
+}
+`
+	expectedAggregateSummary := `{
+  "roots": [
+    "first-project",
+    "second-project"
+  ],
+  "objects": 2,
+  "kinds": {
+    "Deployment.apps": 2
+  },
+  "namespaces": [],
+  "images": []
+}
+`
+
+	require.NoError(
+		t,
+		kustomizeBuildDirs(
+			context.Background(),
+			options{outDir: outDir, dirDepth: mockdirDepth, writeSummaries: true},
+			[]string{firstDeploymentPath, secondDeploymentPath},
+		),
+	)
+	for _, root := range []string{"first-project", "second-project"} {
+		got, err := os.ReadFile(filepath.Join(outDir, root, summaryFileName))
+		require.NoError(t, err)
+		require.Equal(t, expectedRootSummary, string(got))
+	}
+	got, err := os.ReadFile(filepath.Join(outDir, aggregateSummaryFileName))
+	require.NoError(t, err)
+	require.Equal(t, expectedAggregateSummary, string(got))
+}
+
+func TestFailsWhenWritingSummariesToStdout(t *testing.T) {
+	err := kustomizeBuildDirs(
+		context.Background(),
+		options{outDir: stdoutOutDir, dirDepth: mockdirDepth, writeSummaries: true},
+		[]string{},
+	)
+
+	require.EqualError(t, err, "'--summary' can't be used with '--out-dir -'")
+}
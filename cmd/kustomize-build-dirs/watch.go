@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/utilitywarehouse/manifest-checkers/kustomizebuild"
+)
+
+// watchDebounce is how long to wait after a change for any further changes
+// before building, so that e.g. saving several files at once triggers a single
+// build
+const watchDebounce = 200 * time.Millisecond
+
+// watch builds the kustomizations containing the given files, then watches
+// the repo and rebuilds the kustomizations containing any files that change,
+// until ctx is cancelled. Build failures are logged rather than returned
+func watch(ctx context.Context, opts options, filepaths []string) error {
+	if opts.outDir == stdoutOutDir {
+		return errors.New("'watch' can't be used with '--out-dir -'")
+	}
+	if opts.ref != "" {
+		return errors.New("'watch' can't be used with '--ref'")
+	}
+
+	rootDir, err := getwdFunc()
+	if err != nil {
+		return fmt.Errorf("error reading working directory: %v", err)
+	}
+	outDir := opts.outDir
+	if !filepath.IsAbs(outDir) {
+		outDir = filepath.Join(rootDir, outDir)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil { //go-cov:skip
+		return fmt.Errorf("error creating watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	if err := watchDirs(watcher, rootDir, outDir); err != nil {
+		return err
+	}
+
+	if len(filepaths) != 0 {
+		rebuild(ctx, opts, filepaths)
+	}
+	slog.Info("watching for changes", "path", rootDir)
+
+	changed := map[string]struct{}{}
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok { //go-cov:skip
+				return nil
+			}
+			relPath, err := filepath.Rel(rootDir, event.Name)
+			if err != nil || isIgnoredPath(relPath) || kustomizebuild.IsWithinDir(event.Name, outDir) {
+				continue
+			}
+			// builds truncating secrets change them, which mustn't trigger
+			// another build
+			if opts.doTruncateSecrets && isTruncatedSecret(ctx, rootDir, relPath) {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				// watch new directories too
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := watchDirs(watcher, event.Name, outDir); err != nil {
+						slog.Error(err.Error())
+					}
+				}
+			}
+			slog.Debug("changed", "path", relPath, "op", event.Op.String())
+			changed[relPath] = struct{}{}
+			timer.Reset(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok { //go-cov:skip
+				return nil
+			}
+			slog.Error("error watching for changes", "error", err)
+		case <-timer.C:
+			rebuild(ctx, opts, sortedKeys(changed))
+			changed = map[string]struct{}{}
+		}
+	}
+}
+
+// rebuild builds the kustomizations containing the given files, logging
+// rather than returning any failure
+func rebuild(ctx context.Context, opts options, filepaths []string) {
+	if err := kustomizeBuildDirs(ctx, opts, filepaths); err != nil {
+		slog.Error(err.Error())
+		return
+	}
+	slog.Info("build complete", "out-dir", opts.outDir)
+}
+
+// watchDirs adds dir and every directory under it to watcher, other than
+// hidden directories and outDir
+func watchDirs(watcher *fsnotify.Watcher, dir string, outDir string) error {
+	walkFunc := func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != dir && (strings.HasPrefix(entry.Name(), ".") || kustomizebuild.IsWithinDir(path, outDir)) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	}
+	if err := filepath.WalkDir(dir, walkFunc); err != nil {
+		return fmt.Errorf("error watching '%s': %v", dir, err)
+	}
+	return nil
+}
+
+// isTruncatedSecret checks whether the file at a path relative to the repo
+// root is a strongbox encrypted secret that's been emptied, as by a build with
+// '--truncate-secrets'
+func isTruncatedSecret(ctx context.Context, rootDir string, relPath string) bool {
+	info, err := os.Stat(filepath.Join(rootDir, relPath))
+	if err != nil || !info.Mode().IsRegular() || info.Size() != 0 {
+		return false
+	}
+	secrets, err := kustomizebuild.FindSecrets(ctx, rootDir, []string{relPath})
+	return err == nil && len(secrets) != 0
+}
+
+// isIgnoredPath checks whether a path relative to the repo root is hidden or
+// within a hidden directory, like '.git'
+func isIgnoredPath(relPath string) bool {
+	for _, segment := range strings.Split(filepath.ToSlash(relPath), "/") {
+		if strings.HasPrefix(segment, ".") && segment != "." {
+			return true
+		}
+	}
+	return false
+}
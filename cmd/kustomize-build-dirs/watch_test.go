@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchRebuildsChangedKustomizations(t *testing.T) {
+	gitDir, outDir := setupTest(t)
+
+	manifestPath := filepath.Join("manifests", "deployment.yaml")
+	repoFiles := map[string]string{
+		filepath.Join("manifests", "kustomization.yaml"): simpleKustomization,
+		manifestPath: simpleDeployment,
+	}
+	buildGitRepo(t, gitDir, repoFiles)
+	outManifestPath := filepath.Join(outDir, "manifests", manifestFileName)
+	requireEventuallyBuilt := func(expected string) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			got, err := os.ReadFile(outManifestPath)
+			return err == nil && string(got) == expected
+		}, 10*time.Second, 50*time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watchErr := make(chan error)
+	go func() {
+		watchErr <- watch(ctx, options{outDir: outDir, dirDepth: mockdirDepth}, []string{manifestPath})
+	}()
+
+	requireEventuallyBuilt(simpleDeployment)
+
+	changedDeployment := fmt.Sprintf(simpleDeploymentTemplate, "my-changed-app")
+	require.NoError(
+		t,
+		os.WriteFile(filepath.Join(gitDir, manifestPath), []byte(changedDeployment), 0o600),
+	)
+	requireEventuallyBuilt(changedDeployment)
+
+	cancel()
+	require.NoError(t, <-watchErr)
+}
+
+func TestWatchIgnoresTruncatedSecrets(t *testing.T) {
+	gitDir, outDir := setupTest(t)
+
+	manifestPath := filepath.Join("manifests", "deployment.yaml")
+	secretPath := filepath.Join("manifests", "secret.yaml")
+	repoFiles := map[string]string{
+		filepath.Join("manifests", "kustomization.yaml"): simpleKustomization,
+		manifestPath: simpleDeployment,
+		secretPath:   "encrypted-secret\n",
+		".gitattributes": fmt.Sprintf(
+			"%s	filter=strongbox diff=strongbox\n",
+			filepath.ToSlash(secretPath),
+		),
+	}
+	buildGitRepo(t, gitDir, repoFiles)
+	var logs syncBuffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+	builds := func() int { return strings.Count(logs.String(), "build complete") }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watchErr := make(chan error)
+	go func() {
+		watchErr <- watch(
+			ctx,
+			options{outDir: outDir, dirDepth: mockdirDepth, doTruncateSecrets: true},
+			[]string{manifestPath},
+		)
+	}()
+
+	require.Eventually(t, func() bool { return builds() == 1 }, 10*time.Second, 50*time.Millisecond)
+	// the build truncates the secret, and every rebuild does again
+	changedDeployment := fmt.Sprintf(simpleDeploymentTemplate, "my-changed-app")
+	require.NoError(
+		t,
+		os.WriteFile(filepath.Join(gitDir, manifestPath), []byte(changedDeployment), 0o600),
+	)
+	require.Eventually(t, func() bool { return builds() == 2 }, 10*time.Second, 50*time.Millisecond)
+	require.Never(t, func() bool { return builds() > 2 }, 5*watchDebounce, 50*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-watchErr)
+}
+
+// syncBuffer is a bytes.Buffer that's safe to use concurrently
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func TestWatchFailsWithStdout(t *testing.T) {
+	err := watch(
+		context.Background(),
+		options{outDir: stdoutOutDir, dirDepth: mockdirDepth},
+		[]string{},
+	)
+	require.EqualError(t, err, "'watch' can't be used with '--out-dir -'")
+}
+
+func TestIsIgnoredPath(t *testing.T) {
+	for path, expected := range map[string]bool{
+		".":                              false,
+		"manifests/kustomization.yaml":   false,
+		".git":                           true,
+		".git/index":                     true,
+		"manifests/.deployment.yaml.swp": true,
+	} {
+		require.Equal(t, expected, isIgnoredPath(path), path)
+	}
+}
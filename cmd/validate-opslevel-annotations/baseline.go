@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// baselineHeader starts each baseline file written
+const baselineHeader = `# Findings ignored by validate-opslevel-annotations '--baseline', written by
+# '--write-baseline'. Remove entries once they're fixed, or write it again
+`
+
+// baselineEntry identifies a finding for an object, independently of the file
+// and line it's at, so that it's stable across rebuilds of the manifests
+type baselineEntry struct {
+	Kind      string `yaml:"kind"`
+	Namespace string `yaml:"namespace,omitempty"`
+	Name      string `yaml:"name"`
+	Rule      string `yaml:"rule"`
+	// Annotation is the annotation, or annotation prefix, of the finding
+	Annotation string `yaml:"annotation,omitempty"`
+}
+
+// baselineFile lists the findings to ignore
+type baselineFile struct {
+	Findings []baselineEntry `yaml:"findings"`
+}
+
+// baselineEntry returns the entry identifying the finding in a baseline, and
+// whether it can be in one at all, which files that can't be read can't
+func (f finding) baselineEntry() (baselineEntry, bool) {
+	if f.RuleID == ruleIDUnreadable {
+		return baselineEntry{}, false
+	}
+	return baselineEntry{
+		Kind:       f.Kind,
+		Namespace:  f.Namespace,
+		Name:       f.Name,
+		Rule:       f.RuleID,
+		Annotation: f.Annotation,
+	}, true
+}
+
+// loadBaseline reads the entries of a baseline file
+func loadBaseline(filename string) (map[baselineEntry]bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading baseline: %s: %v", filename, err)
+	}
+	var file baselineFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("Failed parsing baseline from %s: %v", filename, err)
+	}
+
+	baseline := map[baselineEntry]bool{}
+	for _, entry := range file.Findings {
+		baseline[entry] = true
+	}
+	return baseline, nil
+}
+
+// writeBaseline writes a baseline file of the findings that fail, so they're
+// ignored from then on. Exempted findings are left out, so that they fail
+// again when their exemption expires
+func writeBaseline(filename string, findings []finding) error {
+	seen := map[baselineEntry]bool{}
+	file := baselineFile{Findings: []baselineEntry{}}
+	for _, f := range failingFindings(findings) {
+		entry, ok := f.baselineEntry()
+		if !ok || seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		file.Findings = append(file.Findings, entry)
+	}
+	// sort for a consistent output, that's easy to review changes to
+	sort.Slice(file.Findings, func(i, j int) bool {
+		a, b := file.Findings[i], file.Findings[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		return a.Annotation < b.Annotation
+	})
+
+	data, err := yaml.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("Failed writing baseline: %s: %v", filename, err)
+	}
+	if err := os.WriteFile(filename, append([]byte(baselineHeader), data...), 0o600); err != nil {
+		return fmt.Errorf("Failed writing baseline: %s: %v", filename, err)
+	}
+	return nil
+}
+
+// applyBaseline marks the findings in baseline as baselined, so that they don't
+// fail. It returns the number of entries in baseline no longer found, which
+// have likely been fixed
+func applyBaseline(findings []finding, baseline map[baselineEntry]bool) int {
+	found := map[baselineEntry]bool{}
+	for i := range findings {
+		entry, ok := findings[i].baselineEntry()
+		if ok && baseline[entry] {
+			findings[i].Baselined = true
+			found[entry] = true
+		}
+	}
+	return len(baseline) - len(found)
+}
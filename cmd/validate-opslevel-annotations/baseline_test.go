@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaselineIgnoresExistingFindings(t *testing.T) {
+	baselineFile := filepath.Join(t.TempDir(), "baseline.yaml")
+	manifest := filepath.Join(testdataDir, "invalid", "MultipleObjectsWithMissing.yaml")
+	rules := defaultRuleSet(t)
+
+	require.NoError(t, writeBaseline(baselineFile, findingsForManifests(rules, []string{manifest})))
+	written, err := os.ReadFile(baselineFile)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		baselineHeader+`findings:
+- kind: CronJob
+  name: bad-cronjob
+  rule: required-annotation
+  annotation: app.uw.systems/description
+- kind: CronJob
+  name: bad-cronjob
+  rule: required-prefix
+  annotation: app.uw.systems/repos
+- kind: StatefulSet
+  name: bad-statefulset
+  rule: required-annotation
+  annotation: app.uw.systems/tier
+`,
+		string(written),
+	)
+
+	baseline, err := loadBaseline(baselineFile)
+	require.NoError(t, err)
+	findings := findingsForManifests(rules, []string{manifest})
+	require.Equal(t, 0, applyBaseline(findings, baseline))
+	require.Empty(t, failingFindings(findings))
+
+	// findings are matched regardless of the file and line they're at
+	newManifest := filepath.Join(t.TempDir(), "manifests.yaml")
+	require.NoError(t, os.WriteFile(newManifest, []byte(`apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: bad-statefulset
+  annotations:
+    app.uw.systems/repos.some-service: https://github.com/utilitywarehouse/some-service
+`), 0o600))
+	findings = findingsForManifests(rules, []string{newManifest})
+	require.Equal(t, 2, applyBaseline(findings, baseline))
+	failing := failingFindings(findings)
+	require.Len(t, failing, 1)
+	require.Equal(t, "app.uw.systems/description", failing[0].Annotation)
+
+	var out bytes.Buffer
+	require.NoError(t, writeGitHub(&out, findings))
+	require.Equal(
+		t,
+		"::error file="+newManifest+",line=5,col=3,title=invalid StatefulSet%3A bad-statefulset::"+
+			"Missing required annotation: app.uw.systems/description\n",
+		out.String(),
+	)
+
+	out.Reset()
+	require.NoError(t, writeSARIF(&out, findings))
+	var log sarifLog
+	require.NoError(t, json.Unmarshal(out.Bytes(), &log))
+	var suppressions [][]sarifSuppression
+	for _, result := range log.Runs[0].Results {
+		suppressions = append(suppressions, result.Suppressions)
+	}
+	require.Equal(
+		t,
+		[][]sarifSuppression{nil, {{Kind: "external", Justification: "in the baseline"}}},
+		suppressions,
+	)
+}
+
+func TestBaselineLeavesOutExemptedAndUnreadableFindings(t *testing.T) {
+	setNow(t, "2026-06-30")
+	baselineFile := filepath.Join(t.TempDir(), "baseline.yaml")
+	manifest := filepath.Join(t.TempDir(), "manifests.yaml")
+	require.NoError(t, os.WriteFile(manifest, []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: exempt-repos
+  annotations:
+    app.uw.systems/description: Does things
+    app.uw.systems/tier: tier_2
+    app.uw.systems/opslevel-exempt: "repos until 2026-12-31"
+`), 0o600))
+	missingFile := filepath.Join(t.TempDir(), "missing.yaml")
+
+	findings := findingsForManifests(defaultRuleSet(t), []string{manifest, missingFile})
+	require.Len(t, findings, 2)
+	require.NoError(t, writeBaseline(baselineFile, findings))
+
+	baseline, err := loadBaseline(baselineFile)
+	require.NoError(t, err)
+	require.Empty(t, baseline)
+}
+
+func TestFailsOnInvalidBaselineFile(t *testing.T) {
+	baselineFile := filepath.Join(t.TempDir(), "baseline.yaml")
+	require.NoError(t, os.WriteFile(baselineFile, []byte("findings:\n  - rules: []\n"), 0o600))
+
+	_, err := loadBaseline(baselineFile)
+	require.ErrorContains(t, err, "Failed parsing baseline from "+baselineFile)
+
+	_, err = loadBaseline(filepath.Join(t.TempDir(), "missing.yaml"))
+	require.ErrorContains(t, err, "Failed reading baseline: ")
+}
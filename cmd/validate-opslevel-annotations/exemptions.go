@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ruleIDInvalidExemption is for exemption annotations that can't be parsed
+const ruleIDInvalidExemption = "invalid-exemption"
+
+// dateLayout is the layout of the date an exemption lasts until
+const dateLayout = "2006-01-02"
+
+// exemptionPattern matches an exemption given in an annotation, e.g.
+// 'repos until 2026-12-31 JIRA-123'
+var exemptionPattern = regexp.MustCompile(`^(\S+)\s+until\s+(\S+)(?:\s+(.*))?$`)
+
+// variables used for testing
+var now = time.Now
+
+// exemption waives findings for an annotation, or annotation prefix, until the
+// end of a date
+type exemption struct {
+	// Namespace, Kind and Name identify the objects exempted by an
+	// exemptions file, with any namespace matching if Namespace is empty
+	Namespace string `yaml:"namespace" json:"-"`
+	Kind      string `yaml:"kind" json:"-"`
+	Name      string `yaml:"name" json:"-"`
+	// Annotation is the annotation, or prefix, findings are waived for. It
+	// may be given without the part up to the last '/', e.g. 'repos' for
+	// 'app.uw.systems/repos', and also covers annotations it's a prefix of
+	// followed by a '.', e.g. 'app.uw.systems/repos.some-service'
+	Annotation string `yaml:"annotation" json:"annotation"`
+	// Until is the last date findings are waived on, e.g. '2026-12-31'
+	Until  string `yaml:"until" json:"until"`
+	Reason string `yaml:"reason" json:"reason,omitempty"`
+	// Expired is set on the exemptions of findings once Until has passed
+	Expired bool `yaml:"-" json:"expired,omitempty"`
+	// inSource is set for exemptions given by annotations, rather than an
+	// exemptions file
+	inSource bool
+}
+
+// exemptionsFile lists exemptions for objects by namespace, kind and name
+type exemptionsFile struct {
+	Exemptions []exemption `yaml:"exemptions"`
+}
+
+// loadExemptions reads exemptions from a YAML file, returning none if filename
+// is empty
+func loadExemptions(filename string) ([]exemption, error) {
+	if filename == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading exemptions: %s: %v", filename, err)
+	}
+	var file exemptionsFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("Failed parsing exemptions from %s: %v", filename, err)
+	}
+	for i, e := range file.Exemptions {
+		if e.Kind == "" || e.Name == "" || e.Annotation == "" {
+			return nil, fmt.Errorf(
+				"Failed parsing exemptions from %s: exemption %d: kind, name and annotation are required",
+				filename,
+				i+1,
+			)
+		}
+		if _, err := time.Parse(dateLayout, e.Until); err != nil {
+			return nil, fmt.Errorf(
+				"Failed parsing exemptions from %s: exemption %d: invalid date '%s', expected YYYY-MM-DD",
+				filename,
+				i+1,
+				e.Until,
+			)
+		}
+	}
+	return file.Exemptions, nil
+}
+
+// parseExemptions parses the exemptions given in an annotation value, separated
+// by ';', each being '<annotation> until <YYYY-MM-DD> [reason]'. It returns why
+// the value is invalid, or "" if it's valid
+func parseExemptions(annotation string, value string) ([]exemption, string) {
+	var exemptions []exemption
+	for _, part := range strings.Split(value, ";") {
+		match := exemptionPattern.FindStringSubmatch(strings.TrimSpace(part))
+		if match == nil {
+			return nil, fmt.Sprintf(
+				"%s: %q, expected '<annotation> until <YYYY-MM-DD> [reason]' separated by ';'",
+				annotation,
+				value,
+			)
+		}
+		if _, err := time.Parse(dateLayout, match[2]); err != nil {
+			return nil, fmt.Sprintf(
+				"%s: %q, expected a date like YYYY-MM-DD, not '%s'",
+				annotation,
+				value,
+				match[2],
+			)
+		}
+		exemptions = append(exemptions, exemption{Annotation: match[1], Until: match[2], Reason: match[3]})
+	}
+	return exemptions, ""
+}
+
+// appliesTo checks whether the exemption applies to a finding for the
+// annotation
+func (e exemption) appliesTo(annotation string) bool {
+	name := annotation
+	if !strings.Contains(e.Annotation, "/") {
+		name = annotation[strings.LastIndex(annotation, "/")+1:]
+	}
+	return name == e.Annotation || strings.HasPrefix(name, e.Annotation+".")
+}
+
+// appliesToObject checks whether the exemption, from an exemptions file,
+// applies to a finding for an object
+func (e exemption) appliesToObject(f finding) bool {
+	return (e.Namespace == "" || e.Namespace == f.Namespace) &&
+		e.Kind == f.Kind &&
+		e.Name == f.Name &&
+		e.appliesTo(f.Annotation)
+}
+
+// expired checks whether the exemption has expired, it lasting until the end of
+// its date
+func (e exemption) expired() bool {
+	return now().Format(dateLayout) > e.Until
+}
+
+// exempt sets the exemption of a finding, unless the finding can't be exempted
+// or already has an exemption that hasn't expired
+func (f *finding) exempt(e exemption) {
+	if f.RuleID == ruleIDUnreadable || f.RuleID == ruleIDInvalidExemption || f.exempted() {
+		return
+	}
+	e.Expired = e.expired()
+	f.Exemption = &e
+}
+
+// exempted checks whether a finding is waived by an exemption that hasn't
+// expired
+func (f finding) exempted() bool {
+	return f.Exemption != nil && !f.Exemption.Expired
+}
+
+// exemptionNote describes the exemption of a finding, if any
+func (f finding) exemptionNote() string {
+	if f.Exemption == nil {
+		return ""
+	}
+	state := "exempt until"
+	if f.Exemption.Expired {
+		state = "exemption expired after"
+	}
+	note := fmt.Sprintf(" (%s %s", state, f.Exemption.Until)
+	if f.Exemption.Reason != "" {
+		note += ": " + f.Exemption.Reason
+	}
+	return note + ")"
+}
+
+// applyExemptions exempts findings for objects matching any of exemptions
+func applyExemptions(findings []finding, exemptions []exemption) {
+	for i := range findings {
+		for _, e := range exemptions {
+			if e.appliesToObject(findings[i]) {
+				findings[i].exempt(e)
+			}
+		}
+	}
+}
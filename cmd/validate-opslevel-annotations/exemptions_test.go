@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setNow(t *testing.T, date string) {
+	t.Helper()
+	today, err := time.Parse(dateLayout, date)
+	require.NoError(t, err)
+	orig := now
+	now = func() time.Time { return today.Add(23 * time.Hour) }
+	t.Cleanup(func() { now = orig })
+}
+
+func TestExemptsFindingsByAnnotation(t *testing.T) {
+	setNow(t, "2026-06-30")
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: exempt-repos
+  annotations:
+    app.uw.systems/description: Does things
+    app.uw.systems/tier: tier_2
+    app.uw.systems/opslevel-exempt: "repos until 2026-06-30 JIRA-123"
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: expired
+  annotations:
+    app.uw.systems/description: Does things
+    app.uw.systems/opslevel-exempt: "tier until 2026-01-31; app.uw.systems/repos until 2026-12-31"
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: invalid-exemption
+  annotations:
+    app.uw.systems/description: Does things
+    app.uw.systems/tier: tier_2
+    app.uw.systems/repos.some-service: https://github.com/utilitywarehouse/some-service
+    app.uw.systems/opslevel-exempt: "repos until end of year"
+`
+	manifestPath := filepath.Join(t.TempDir(), "manifests.yaml")
+	require.NoError(t, os.WriteFile(manifestPath, []byte(manifest), 0o600))
+
+	findings := findingsForManifests(defaultRuleSet(t), []string{manifestPath})
+	var errOut bytes.Buffer
+	err := reportFindings(&bytes.Buffer{}, &errOut, formatText, findings)
+
+	require.EqualError(
+		t,
+		err,
+		"failed validating manifests from "+manifestPath+": invalid Deployment: expired: "+
+			"Missing required annotations:\n\tapp.uw.systems/tier (exemption expired after 2026-01-31)\n"+
+			"invalid Deployment: invalid-exemption: Invalid annotation values:\n"+
+			"\tapp.uw.systems/opslevel-exempt: \"repos until end of year\", "+
+			"expected a date like YYYY-MM-DD, not 'end'",
+	)
+	require.Equal(
+		t,
+		"exempted: "+manifestPath+": invalid Deployment: exempt-repos: "+
+			"No annotation found with required prefix: app.uw.systems/repos (exempt until 2026-06-30: JIRA-123)\n"+
+			"exempted: "+manifestPath+": invalid Deployment: expired: "+
+			"No annotation found with required prefix: app.uw.systems/repos (exempt until 2026-12-31)\n",
+		errOut.String(),
+	)
+}
+
+func TestExemptsFindingsFromFile(t *testing.T) {
+	setNow(t, "2026-06-30")
+	exemptionsFile := filepath.Join(t.TempDir(), "exemptions.yaml")
+	exemptions := `exemptions:
+  - kind: CronJob
+    name: bad-cronjob
+    annotation: app.uw.systems/description
+    until: 2026-07-01
+    reason: JIRA-123
+  - kind: CronJob
+    name: bad-cronjob
+    namespace: other-namespace
+    annotation: repos
+    until: 2026-07-01
+  - kind: StatefulSet
+    name: bad-statefulset
+    annotation: tier
+    until: 2026-06-29
+`
+	require.NoError(t, os.WriteFile(exemptionsFile, []byte(exemptions), 0o600))
+	manifest := filepath.Join(testdataDir, "invalid", "MultipleObjectsWithMissing.yaml")
+
+	loaded, err := loadExemptions(exemptionsFile)
+	require.NoError(t, err)
+	findings := findingsForManifests(defaultRuleSet(t), []string{manifest})
+	applyExemptions(findings, loaded)
+
+	var exempted []string
+	for _, f := range findings {
+		if f.exempted() {
+			exempted = append(exempted, f.Name+": "+f.Annotation)
+		}
+	}
+	require.Equal(t, []string{"bad-cronjob: app.uw.systems/description"}, exempted)
+	require.True(t, findings[2].Exemption.Expired)
+
+	var out bytes.Buffer
+	require.NoError(t, writeGitHub(&out, findings[:1]))
+	require.Contains(t, out.String(), "::notice ")
+
+	out.Reset()
+	require.NoError(t, writeSARIF(&out, findings[:1]))
+	require.Contains(t, out.String(), `"kind": "external"`)
+}
+
+func TestFailsOnInvalidExemptionsFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		exemptions  string
+		expectedErr string
+	}{
+		{
+			name:        "unknown field",
+			exemptions:  "exemptions:\n  - kind: Deployment\n    expires: 2026-01-01\n",
+			expectedErr: "field expires not found",
+		},
+		{
+			name:        "missing name",
+			exemptions:  "exemptions:\n  - kind: Deployment\n    annotation: tier\n    until: 2026-01-01\n",
+			expectedErr: "exemption 1: kind, name and annotation are required",
+		},
+		{
+			name: "invalid date",
+			exemptions: "exemptions:\n  - kind: Deployment\n    name: some-app\n    annotation: tier\n" +
+				"    until: 01/01/2026\n",
+			expectedErr: "exemption 1: invalid date '01/01/2026', expected YYYY-MM-DD",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exemptionsFile := filepath.Join(t.TempDir(), "exemptions.yaml")
+			require.NoError(t, os.WriteFile(exemptionsFile, []byte(tt.exemptions), 0o600))
+
+			_, err := loadExemptions(exemptionsFile)
+
+			require.ErrorContains(t, err, "Failed parsing exemptions from "+exemptionsFile)
+			require.ErrorContains(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestExemptionAppliesTo(t *testing.T) {
+	tests := []struct {
+		exemption  string
+		annotation string
+		expected   bool
+	}{
+		{exemption: "app.uw.systems/tier", annotation: "app.uw.systems/tier", expected: true},
+		{exemption: "tier", annotation: "app.uw.systems/tier", expected: true},
+		{exemption: "repos", annotation: "app.uw.systems/repos.some-service", expected: true},
+		{exemption: "repos", annotation: "app.uw.systems/reposition", expected: false},
+		{exemption: "example.com/tier", annotation: "app.uw.systems/tier", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.exemption+" "+tt.annotation, func(t *testing.T) {
+			require.Equal(t, tt.expected, exemption{Annotation: tt.exemption}.appliesTo(tt.annotation))
+		})
+	}
+}
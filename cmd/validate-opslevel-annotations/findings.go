@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IDs of the rules a finding can be for
+const (
+	ruleIDRequiredAnnotation = "required-annotation"
+	ruleIDRequiredPrefix     = "required-prefix"
+	ruleIDAnnotationValue    = "annotation-value"
+	// ruleIDUnreadable is for manifest files that couldn't be read or decoded
+	ruleIDUnreadable = "unreadable-manifest"
+)
+
+// ruleDescriptions describes each rule ID, e.g. for SARIF output
+var ruleDescriptions = map[string]string{
+	ruleIDRequiredAnnotation: "Required OpsLevel annotation is missing",
+	ruleIDRequiredPrefix:     "No annotation found with a required OpsLevel annotation prefix",
+	ruleIDAnnotationValue:    "OpsLevel annotation has an invalid value",
+	ruleIDUnreadable:         "Manifest file couldn't be read or decoded",
+	ruleIDInvalidExemption:   "Exemption annotation can't be parsed",
+}
+
+// Formats findings can be output in
+const (
+	formatText   = "text"
+	formatJSON   = "json"
+	formatSARIF  = "sarif"
+	formatGitHub = "github"
+)
+
+// findingWriters write findings in each format but text, which is reported as
+// the error message instead
+var findingWriters = map[string]func(io.Writer, []finding) error{
+	formatJSON:   writeJSON,
+	formatSARIF:  writeSARIF,
+	formatGitHub: writeGitHub,
+}
+
+// finding is a single problem found in a manifest file
+type finding struct {
+	File string `json:"file"`
+	// DocumentIndex is the index of the YAML document in the file the object
+	// was decoded from, starting at 0
+	DocumentIndex int `json:"documentIndex"`
+	// DocumentLine is the line the object's manifest starts at
+	DocumentLine int `json:"documentLine,omitempty"`
+	// Line and Column are where in the file the finding is, i.e. the
+	// annotation with an invalid value, or else the object's annotations
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	RuleID    string `json:"ruleId"`
+	// Annotation is the annotation, or annotation prefix, the finding is for
+	Annotation string `json:"annotation,omitempty"`
+	Message    string `json:"message"`
+	// Exemption waives the finding, unless it has expired
+	Exemption *exemption `json:"exemption,omitempty"`
+	// Baselined is set for findings in the baseline, which don't fail
+	Baselined bool `json:"baselined,omitempty"`
+}
+
+// failingFindings returns the findings that haven't been exempted or baselined
+func failingFindings(findings []finding) []finding {
+	var failing []finding
+	for _, f := range findings {
+		if !f.exempted() && !f.Baselined {
+			failing = append(failing, f)
+		}
+	}
+	return failing
+}
+
+// object describes the object a finding is for, if any
+func (f finding) object() string {
+	if f.Kind == "" {
+		return ""
+	}
+	return fmt.Sprintf("invalid %s: %s", f.Kind, f.Name)
+}
+
+// describe describes the finding, along with the object it's for and any
+// exemption
+func (f finding) describe() string {
+	description := f.Message
+	if object := f.object(); object != "" {
+		description = object + ": " + description
+	}
+	return description + f.exemptionNote()
+}
+
+// textFindings formats findings as text, grouping them by file and object
+func textFindings(findings []finding) string {
+	var fileStrings []string
+	for _, fileFindings := range groupFindings(findings, func(f finding) string { return f.File }) {
+		if fileFindings[0].RuleID == ruleIDUnreadable {
+			fileStrings = append(fileStrings, fileFindings[0].Message)
+			continue
+		}
+
+		var objectErrStrings []string
+		objectGroups := groupFindings(fileFindings, func(f finding) string {
+			return fmt.Sprint(f.DocumentIndex)
+		})
+		for _, objectFindings := range objectGroups {
+			objectErrStrings = append(
+				objectErrStrings,
+				objectFindings[0].object()+": "+formatObjectFindings(objectFindings),
+			)
+		}
+		fileStrings = append(
+			fileStrings,
+			fmt.Sprintf(
+				"failed validating manifests from %s: %s",
+				fileFindings[0].File,
+				strings.Join(objectErrStrings, "\n"),
+			),
+		)
+	}
+	return strings.Join(fileStrings, "\n")
+}
+
+// formatObjectFindings formats the findings for a single object as text
+func formatObjectFindings(findings []finding) string {
+	var missingRequiredAnnotations []string
+	var missingPrefixAnnotations []string
+	var invalidValues []string
+	for _, f := range findings {
+		switch f.RuleID {
+		case ruleIDRequiredAnnotation:
+			missingRequiredAnnotations = append(missingRequiredAnnotations, f.Annotation+f.exemptionNote())
+		case ruleIDRequiredPrefix:
+			missingPrefixAnnotations = append(missingPrefixAnnotations, f.Annotation+f.exemptionNote())
+		case ruleIDAnnotationValue, ruleIDInvalidExemption:
+			invalidValues = append(invalidValues, f.Message+f.exemptionNote())
+		}
+	}
+
+	var errStrings []string
+	if len(missingRequiredAnnotations) != 0 {
+		errStrings = append(
+			errStrings,
+			fmt.Sprintf(
+				"Missing required annotations:\n\t%s",
+				strings.Join(missingRequiredAnnotations, "\n\t"),
+			),
+		)
+	}
+	if len(missingPrefixAnnotations) != 0 {
+		errStrings = append(
+			errStrings,
+			fmt.Sprintf(
+				"No annotation found with required prefixes:\n\t%s",
+				strings.Join(missingPrefixAnnotations, "\n\t"),
+			),
+		)
+	}
+	if len(invalidValues) != 0 {
+		errStrings = append(
+			errStrings,
+			fmt.Sprintf(
+				"Invalid annotation values:\n\t%s",
+				strings.Join(invalidValues, "\n\t"),
+			),
+		)
+	}
+	return strings.Join(errStrings, "\n")
+}
+
+// groupFindings splits findings into runs with the same key, keeping their
+// order
+func groupFindings(findings []finding, key func(finding) string) [][]finding {
+	var groups [][]finding
+	for i, f := range findings {
+		if i == 0 || key(f) != key(findings[i-1]) {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], f)
+	}
+	return groups
+}
+
+// writeJSON writes findings as a JSON array
+func writeJSON(w io.Writer, findings []finding) error {
+	if findings == nil {
+		findings = []finding{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(findings)
+}
+
+// sarifLog is the subset of a SARIF 2.1.0 log needed to report findings, e.g. to
+// GitHub code scanning
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name  string      `json:"name"`
+			Rules []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID       string             `json:"ruleId"`
+	Level        string             `json:"level"`
+	Message      sarifMessage       `json:"message"`
+	Locations    []sarifLocation    `json:"locations,omitempty"`
+	Suppressions []sarifSuppression `json:"suppressions,omitempty"`
+}
+
+type sarifSuppression struct {
+	// Kind is 'inSource' for exemptions given by annotations, and 'external'
+	// for those from an exemptions file or the baseline
+	Kind          string `json:"kind"`
+	Justification string `json:"justification,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region *sarifRegion `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// writeSARIF writes findings as a SARIF log
+func writeSARIF(w io.Writer, findings []finding) error {
+	run := sarifRun{Results: []sarifResult{}}
+	run.Tool.Driver.Name = "validate-opslevel-annotations"
+	for id, description := range ruleDescriptions {
+		run.Tool.Driver.Rules = append(
+			run.Tool.Driver.Rules,
+			sarifRule{ID: id, ShortDescription: sarifMessage{Text: description}},
+		)
+	}
+	// sort for a consistent output
+	sort.Slice(run.Tool.Driver.Rules, func(i, j int) bool {
+		return run.Tool.Driver.Rules[i].ID < run.Tool.Driver.Rules[j].ID
+	})
+
+	for _, f := range findings {
+		result := sarifResult{
+			RuleID:  f.RuleID,
+			Level:   "error",
+			Message: sarifMessage{Text: f.describe()},
+		}
+		if f.exempted() {
+			kind := "external"
+			if f.Exemption.inSource {
+				kind = "inSource"
+			}
+			result.Suppressions = []sarifSuppression{
+				{Kind: kind, Justification: f.Exemption.Reason},
+			}
+		} else if f.Baselined {
+			result.Suppressions = []sarifSuppression{
+				{Kind: "external", Justification: "in the baseline"},
+			}
+		}
+		// manifests read from stdin have no location
+		if f.File != stdinArg {
+			var location sarifLocation
+			location.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(f.File)
+			if f.Line != 0 {
+				location.PhysicalLocation.Region = &sarifRegion{
+					StartLine:   f.Line,
+					StartColumn: f.Column,
+				}
+			}
+			result.Locations = []sarifLocation{location}
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
+
+// writeGitHub writes findings as GitHub Actions workflow commands, so they're
+// shown as annotations on pull requests
+func writeGitHub(w io.Writer, findings []finding) error {
+	for _, f := range findings {
+		// there may be hundreds of findings in the baseline, which aren't
+		// worth annotating pull requests with
+		if f.Baselined {
+			continue
+		}
+		var properties []string
+		if f.File != stdinArg {
+			properties = append(properties, "file="+escapeGitHubProperty(f.File))
+			if f.Line != 0 {
+				properties = append(
+					properties,
+					fmt.Sprintf("line=%d", f.Line),
+					fmt.Sprintf("col=%d", f.Column),
+				)
+			}
+		}
+		title := f.RuleID
+		if object := f.object(); object != "" {
+			title = object
+		}
+		properties = append(properties, "title="+escapeGitHubProperty(title))
+
+		// exempted findings are shown, but don't fail checks
+		command := "error"
+		if f.exempted() {
+			command = "notice"
+		}
+		if _, err := fmt.Fprintf(
+			w,
+			"::%s %s::%s\n",
+			command,
+			strings.Join(properties, ","),
+			escapeGitHubData(f.Message+f.exemptionNote()),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escapeGitHubData escapes the message of a GitHub Actions workflow command
+func escapeGitHubData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeGitHubProperty escapes a property value of a GitHub Actions workflow
+// command
+func escapeGitHubProperty(s string) string {
+	return strings.NewReplacer(
+		"%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C",
+	).Replace(s)
+}
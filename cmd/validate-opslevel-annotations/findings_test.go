@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportsFindings(t *testing.T) {
+	manifest := filepath.Join(testdataDir, "invalid", "MultipleObjectsWithMissing.yaml")
+	missingFile := filepath.Join(t.TempDir(), "missing.yaml")
+
+	findings := findingsForManifests(defaultRuleSet(t), []string{manifest, missingFile})
+
+	require.Len(t, findings, 4)
+	require.Equal(
+		t,
+		[]finding{
+			{
+				File:          manifest,
+				DocumentIndex: 1,
+				DocumentLine:  9,
+				Line:          13,
+				Column:        3,
+				Kind:          "CronJob",
+				Name:          "bad-cronjob",
+				RuleID:        ruleIDRequiredAnnotation,
+				Annotation:    "app.uw.systems/description",
+				Message:       "Missing required annotation: app.uw.systems/description",
+			},
+			{
+				File:          manifest,
+				DocumentIndex: 1,
+				DocumentLine:  9,
+				Line:          13,
+				Column:        3,
+				Kind:          "CronJob",
+				Name:          "bad-cronjob",
+				RuleID:        ruleIDRequiredPrefix,
+				Annotation:    "app.uw.systems/repos",
+				Message:       "No annotation found with required prefix: app.uw.systems/repos",
+			},
+			{
+				File:          manifest,
+				DocumentIndex: 2,
+				DocumentLine:  16,
+				Line:          20,
+				Column:        3,
+				Kind:          "StatefulSet",
+				Name:          "bad-statefulset",
+				RuleID:        ruleIDRequiredAnnotation,
+				Annotation:    "app.uw.systems/tier",
+				Message:       "Missing required annotation: app.uw.systems/tier",
+			},
+			{
+				File:    missingFile,
+				RuleID:  ruleIDUnreadable,
+				Message: findings[3].Message,
+			},
+		},
+		findings,
+	)
+}
+
+func TestWritesFindings(t *testing.T) {
+	findings := []finding{
+		{
+			File:       "manifests.yaml",
+			Kind:       "Deployment",
+			Name:       "bad-deployment",
+			RuleID:     ruleIDRequiredAnnotation,
+			Annotation: "app.uw.systems/tier",
+			Message:    "Missing required annotation: app.uw.systems/tier",
+		},
+		{
+			File:    stdinArg,
+			RuleID:  ruleIDUnreadable,
+			Message: "Failed reading manifests from -: bad, 100%\nbroken",
+		},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, writeJSON(&out, findings))
+
+		var got []finding
+		require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+		require.Equal(t, findings, got)
+	})
+
+	t.Run("sarif", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, writeSARIF(&out, findings))
+
+		var got sarifLog
+		require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+		require.Equal(t, "2.1.0", got.Version)
+		require.Len(t, got.Runs, 1)
+		require.Len(t, got.Runs[0].Tool.Driver.Rules, len(ruleDescriptions))
+		results := got.Runs[0].Results
+		require.Len(t, results, 2)
+		require.Equal(t, ruleIDRequiredAnnotation, results[0].RuleID)
+		require.Equal(
+			t,
+			"invalid Deployment: bad-deployment: Missing required annotation: app.uw.systems/tier",
+			results[0].Message.Text,
+		)
+		require.Equal(t, "manifests.yaml", results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI)
+		require.Empty(t, results[1].Locations)
+	})
+
+	t.Run("github", func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, writeGitHub(&out, findings))
+
+		require.Equal(
+			t,
+			"::error file=manifests.yaml,title=invalid Deployment%3A bad-deployment::"+
+				"Missing required annotation: app.uw.systems/tier\n"+
+				"::error title=unreadable-manifest::"+
+				"Failed reading manifests from -: bad, 100%25%0Abroken\n",
+			out.String(),
+		)
+	})
+}
+
+func TestReportFindingsMarksErrorReported(t *testing.T) {
+	manifest := filepath.Join(testdataDir, "invalid", "SingleDeploymentMissingAll.yaml")
+	findings := findingsForManifests(defaultRuleSet(t), []string{manifest})
+
+	var out bytes.Buffer
+	err := reportFindings(&out, io.Discard, formatJSON, findings)
+
+	require.EqualError(t, err, "found 3 problems with manifests")
+	require.Equal(t, exitCodeInvalid, exitCode(err))
+	require.Contains(t, out.String(), `"ruleId": "required-prefix"`)
+}
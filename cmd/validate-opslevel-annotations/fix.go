@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fixPlaceholder is the value of annotations added by '--fix', to be replaced
+// by hand
+const fixPlaceholder = "TODO"
+
+// fixManifests adds the annotations, and annotations with the prefixes, that
+// findings report missing to the manifest files they're from, with placeholder
+// values. Manifests are edited as text, so comments, ordering and anchors are
+// kept as they are. What was, and couldn't be, fixed is written to w
+func fixManifests(w io.Writer, findings []finding) error {
+	for _, fileFindings := range groupFindings(findings, func(f finding) string { return f.File }) {
+		manifestFile := fileFindings[0].File
+		if manifestFile == stdinArg || fileFindings[0].RuleID == ruleIDUnreadable {
+			continue
+		}
+
+		messages, err := fixManifestFile(manifestFile, fileFindings)
+		if err != nil {
+			return fmt.Errorf("Failed fixing manifests in %s: %v", manifestFile, err)
+		}
+		for _, message := range messages {
+			fmt.Fprintf(w, "%s: %s\n", manifestFile, message)
+		}
+	}
+	return nil
+}
+
+// fixManifestFile adds the annotations missing for findings to a manifest
+// file, returning a message for each object saying what was added, or why
+// nothing could be
+func fixManifestFile(manifestFile string, findings []finding) ([]string, error) {
+	info, err := os.Stat(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+
+	documents, err := decodeDocuments(data)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+
+	var messages []string
+	var insertions []insertion
+	for _, objectFindings := range groupFindings(findings, func(f finding) string {
+		return fmt.Sprint(f.DocumentIndex)
+	}) {
+		var missing []string
+		seen := map[string]bool{}
+		for _, f := range objectFindings {
+			annotation := f.Annotation
+			switch f.RuleID {
+			case ruleIDRequiredAnnotation:
+			case ruleIDRequiredPrefix:
+				// the prefix with a placeholder suffix, which fails as
+				// being a placeholder too, if the prefix needs one
+				annotation = placeholderPrefixAnnotation(annotation)
+			default:
+				continue
+			}
+			if !seen[annotation] {
+				missing = append(missing, annotation)
+				seen[annotation] = true
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		object := fmt.Sprintf("%s %s", objectFindings[0].Kind, objectFindings[0].Name)
+		documentIndex := objectFindings[0].DocumentIndex
+		if documentIndex >= len(documents) {
+			return nil, fmt.Errorf("no document %d", documentIndex)
+		}
+		objectInsertion, err := annotationsInsertion(documents[documentIndex], missing)
+		if err != nil {
+			messages = append(messages, fmt.Sprintf("can't add annotations to %s: %v", object, err))
+			continue
+		}
+		insertions = append(insertions, objectInsertion)
+		messages = append(
+			messages,
+			fmt.Sprintf("added annotations to %s: %s", object, strings.Join(missing, ", ")),
+		)
+	}
+	if len(insertions) == 0 {
+		return messages, nil
+	}
+
+	// insert from the end, so that the lines of earlier insertions don't move
+	sort.Slice(insertions, func(i, j int) bool { return insertions[i].line > insertions[j].line })
+	for _, i := range insertions {
+		// the line inserted before may be the last, without a newline
+		if i.line > 0 && !strings.HasSuffix(lines[i.line-1], "\n") {
+			lines[i.line-1] += "\n"
+		}
+		lines = append(lines[:i.line], append([]string{i.text}, lines[i.line:]...)...)
+	}
+
+	if err := os.WriteFile(manifestFile, []byte(strings.Join(lines, "")), info.Mode().Perm()); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// insertion is text to insert into a manifest file
+type insertion struct {
+	// line is the index of the line to insert text before, starting at 0
+	line int
+	text string
+}
+
+// decodeDocuments decodes the content of every YAML document in data, nil for
+// empty documents, in the same order as decodeManifest
+func decodeDocuments(data []byte) ([]*yaml.Node, error) {
+	decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+
+	var documents []*yaml.Node
+	for {
+		var node yaml.Node
+		if err := decoder.Decode(&node); err != nil {
+			if err == io.EOF {
+				return documents, nil
+			}
+			return nil, err
+		}
+		var content *yaml.Node
+		if len(node.Content) != 0 {
+			content = node.Content[0]
+		}
+		documents = append(documents, content)
+	}
+}
+
+// annotationsInsertion returns the insertion that adds annotations, with
+// placeholder values, to the object decoded from node. They're added before
+// any existing annotations, or as the first entry of the object's metadata if
+// it has none. Annotations only given by an alias, or in flow style, e.g.
+// '{a: b}', aren't supported
+func annotationsInsertion(node *yaml.Node, annotations []string) (insertion, error) {
+	if node == nil {
+		return insertion{}, fmt.Errorf("empty document")
+	}
+	metadataKey, metadata := mappingValue(node, "metadata")
+	if metadataKey == nil {
+		return insertion{}, fmt.Errorf("no metadata")
+	}
+	if metadata.Kind == yaml.AliasNode {
+		return insertion{}, fmt.Errorf("metadata is an alias")
+	}
+
+	entries, err := yaml.Marshal(placeholderAnnotations(annotations))
+	if err != nil {
+		return insertion{}, err
+	}
+
+	annotationsKey, annotationsValue := mappingValue(metadata, "annotations")
+	switch {
+	case annotationsKey == nil:
+		first, err := firstBlockKey(metadata, "metadata")
+		if err != nil {
+			return insertion{}, err
+		}
+		indent := strings.Repeat(" ", first.Column-1)
+		return insertion{
+			line: first.Line - 1,
+			text: indent + "annotations:\n" + indentLines(string(entries), indent+"  "),
+		}, nil
+	case annotationsValue.Kind == yaml.AliasNode:
+		return insertion{}, fmt.Errorf("annotations are an alias")
+	default:
+		first, err := firstBlockKey(annotationsValue, "annotations")
+		if err != nil {
+			return insertion{}, err
+		}
+		return insertion{
+			line: first.Line - 1,
+			text: indentLines(string(entries), strings.Repeat(" ", first.Column-1)),
+		}, nil
+	}
+}
+
+// firstBlockKey returns the first key of node, a block style mapping, that
+// holds what's described by name
+func firstBlockKey(node *yaml.Node, name string) (*yaml.Node, error) {
+	if node.Kind != yaml.MappingNode || node.Style&yaml.FlowStyle != 0 || len(node.Content) == 0 {
+		return nil, fmt.Errorf("%s isn't a block mapping", name)
+	}
+	return node.Content[0], nil
+}
+
+// placeholderPrefixAnnotation returns the annotation to add for a required
+// prefix, e.g. 'app.uw.systems/repos.TODO' for 'app.uw.systems/repos', so
+// that it's clear the name needs replacing as well as the value
+func placeholderPrefixAnnotation(prefix string) string {
+	if strings.HasSuffix(prefix, "/") || strings.HasSuffix(prefix, ".") {
+		return prefix + fixPlaceholder
+	}
+	return prefix + "." + fixPlaceholder
+}
+
+// placeholderAnnotations returns annotations with placeholder values, keeping
+// their order
+func placeholderAnnotations(annotations []string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, annotation := range annotations {
+		node.Content = append(
+			node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: annotation},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: fixPlaceholder},
+		)
+	}
+	return node
+}
+
+// indentLines prefixes each line of s with indent
+func indentLines(s string, indent string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = indent + line
+		}
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixesManifests(t *testing.T) {
+	manifest := `# deployments of the app
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  # the app's name
+  name: &app some-app
+  annotations:
+    app.uw.systems/description: "Does things"
+spec:
+  selector:
+    matchLabels:
+      app: *app
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: no-annotations
+  labels: &labels
+    app: some-app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: alias-annotations
+  annotations: *labels
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: flow-annotations
+  annotations: {app.uw.systems/description: Does things}
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: flow-metadata}`
+	expected := `# deployments of the app
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  # the app's name
+  name: &app some-app
+  annotations:
+    app.uw.systems/tier: TODO
+    app.uw.systems/repos.TODO: TODO
+    app.uw.systems/description: "Does things"
+spec:
+  selector:
+    matchLabels:
+      app: *app
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  annotations:
+    app.uw.systems/description: TODO
+    app.uw.systems/tier: TODO
+    app.uw.systems/repos.TODO: TODO
+  name: no-annotations
+  labels: &labels
+    app: some-app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: alias-annotations
+  annotations: *labels
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: flow-annotations
+  annotations: {app.uw.systems/description: Does things}
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: flow-metadata}`
+	manifestPath := filepath.Join(t.TempDir(), "manifests.yaml")
+	require.NoError(t, os.WriteFile(manifestPath, []byte(manifest), 0o640))
+
+	var out bytes.Buffer
+	require.NoError(
+		t,
+		fixManifests(&out, findingsForManifests(defaultRuleSet(t), []string{manifestPath})),
+	)
+
+	fixed, err := os.ReadFile(manifestPath)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(fixed))
+	info, err := os.Stat(manifestPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+	require.Equal(
+		t,
+		manifestPath+": added annotations to Deployment some-app: app.uw.systems/tier, app.uw.systems/repos.TODO\n"+
+			manifestPath+": added annotations to StatefulSet no-annotations: "+
+			"app.uw.systems/description, app.uw.systems/tier, app.uw.systems/repos.TODO\n"+
+			manifestPath+": can't add annotations to Deployment alias-annotations: "+
+			"annotations are an alias\n"+
+			manifestPath+": can't add annotations to Deployment flow-annotations: "+
+			"annotations isn't a block mapping\n"+
+			manifestPath+": can't add annotations to Deployment flow-metadata: "+
+			"metadata isn't a block mapping\n",
+		out.String(),
+	)
+}
+
+func TestFailsOnFixPlaceholders(t *testing.T) {
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: fixed
+  annotations:
+    app.uw.systems/description: TODO
+    app.uw.systems/tier: TODO
+    app.uw.systems/repos.TODO: TODO
+`
+	manifestPath := filepath.Join(t.TempDir(), "manifests.yaml")
+	require.NoError(t, os.WriteFile(manifestPath, []byte(manifest), 0o600))
+
+	code, _, errOut := runApp(t, manifestPath)
+
+	require.Equal(t, exitCodeInvalid, code)
+	require.Equal(
+		t,
+		"failed: failed validating manifests from "+manifestPath+": invalid Deployment: fixed: Invalid annotation values:\n"+
+			"\tapp.uw.systems/description: \"TODO\", a placeholder added by '--fix' to be replaced\n"+
+			"\tapp.uw.systems/repos.TODO: \"TODO\", a placeholder added by '--fix' to be replaced\n"+
+			"\tapp.uw.systems/tier: \"TODO\", a placeholder added by '--fix' to be replaced\n",
+		errOut,
+	)
+}
+
+func TestPlaceholderPrefixAnnotation(t *testing.T) {
+	for prefix, expected := range map[string]string{
+		"app.uw.systems/repos":  "app.uw.systems/repos.TODO",
+		"app.uw.systems/repos.": "app.uw.systems/repos.TODO",
+		"example.com/":          "example.com/TODO",
+	} {
+		require.Equal(t, expected, placeholderPrefixAnnotation(prefix), prefix)
+	}
+}
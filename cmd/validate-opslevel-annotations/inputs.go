@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stdinArg is the argument for reading manifests from stdin, e.g. as piped
+// from `kustomize build`
+const stdinArg = "-"
+
+// variables used for testing
+var stdin io.Reader = os.Stdin
+
+// findManifestFiles expands the given arguments into the manifest files to
+// validate. Directories are searched recursively for files with names matching
+// any of the include patterns, skipping hidden directories like '.git', while
+// files and stdinArg are used as given. Paths that can't be searched are
+// returned as unreadable findings, rather than stopping the search
+func findManifestFiles(args []string, includes []string) ([]string, []finding, error) {
+	for _, include := range includes {
+		if _, err := filepath.Match(include, ""); err != nil {
+			return nil, nil, fmt.Errorf("invalid include pattern '%s': %v", include, err)
+		}
+	}
+
+	var manifestFiles []string
+	var unreadable []finding
+	for _, arg := range args {
+		if arg == stdinArg {
+			manifestFiles = append(manifestFiles, arg)
+			continue
+		}
+		info, err := os.Stat(arg)
+		if err != nil || !info.IsDir() {
+			// errors are reported when opening the file
+			manifestFiles = append(manifestFiles, arg)
+			continue
+		}
+
+		walkFunc := func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				unreadable = append(unreadable, finding{
+					File:    path,
+					RuleID:  ruleIDUnreadable,
+					Message: fmt.Sprintf("Failed searching for manifests in %s: %v", path, err),
+				})
+				return nil
+			}
+			if entry.IsDir() {
+				if path != arg && strings.HasPrefix(entry.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			for _, include := range includes {
+				if matched, _ := filepath.Match(include, entry.Name()); matched {
+					manifestFiles = append(manifestFiles, path)
+					break
+				}
+			}
+			return nil
+		}
+		if err := filepath.WalkDir(arg, walkFunc); err != nil { //go-cov:skip
+			return nil, nil, fmt.Errorf("Failed searching for manifests in %s: %v", arg, err)
+		}
+	}
+	return manifestFiles, unreadable, nil
+}
+
+// openManifest opens a manifest file, or stdin for stdinArg
+func openManifest(manifestFile string) (io.ReadCloser, error) {
+	if manifestFile == stdinArg {
+		return io.NopCloser(stdin), nil
+	}
+	return os.Open(manifestFile)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindManifestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{
+		"deployment.yaml",
+		"README.md",
+		filepath.Join("nested", "statefulset.yml"),
+		filepath.Join("nested", "kustomization.json"),
+		filepath.Join(".git", "config.yaml"),
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), nil, 0o600))
+	}
+
+	manifestFiles, unreadable, err := findManifestFiles(
+		[]string{"some-file.yaml", stdinArg, dir},
+		[]string{"*.yaml", "*.yml"},
+	)
+	require.NoError(t, err)
+	require.Empty(t, unreadable)
+	require.Equal(
+		t,
+		[]string{
+			"some-file.yaml",
+			stdinArg,
+			filepath.Join(dir, "deployment.yaml"),
+			filepath.Join(dir, "nested", "statefulset.yml"),
+		},
+		manifestFiles,
+	)
+}
+
+func TestFindManifestFilesFailsOnInvalidInclude(t *testing.T) {
+	_, _, err := findManifestFiles([]string{}, []string{"[.yaml"})
+
+	require.ErrorContains(t, err, "invalid include pattern '[.yaml'")
+}
+
+func TestFindManifestFilesContinuesPastUnreadableDirectories(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{
+		filepath.Join("a", "deployment.yaml"),
+		filepath.Join("b", "deployment.yaml"),
+		filepath.Join("c", "deployment.yaml"),
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), nil, 0o600))
+	}
+	unreadableDir := filepath.Join(dir, "b")
+	require.NoError(t, os.Chmod(unreadableDir, 0o200))
+	t.Cleanup(func() { _ = os.Chmod(unreadableDir, 0o700) })
+
+	manifestFiles, unreadable, err := findManifestFiles([]string{dir}, []string{"*.yaml"})
+
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		[]string{filepath.Join(dir, "a", "deployment.yaml"), filepath.Join(dir, "c", "deployment.yaml")},
+		manifestFiles,
+	)
+	require.Len(t, unreadable, 1)
+	require.Equal(t, unreadableDir, unreadable[0].File)
+	require.Equal(t, ruleIDUnreadable, unreadable[0].RuleID)
+	require.Contains(t, unreadable[0].Message, "Failed searching for manifests in "+unreadableDir)
+}
+
+func TestValidatesManifestsFromStdin(t *testing.T) {
+	manifest, err := os.ReadFile(
+		filepath.Join(testdataDir, "invalid", "SingleDeploymentMissingDescription.yaml"),
+	)
+	require.NoError(t, err)
+	orig := stdin
+	stdin = strings.NewReader(string(manifest))
+	t.Cleanup(func() { stdin = orig })
+
+	code, _, errOut := runApp(t, stdinArg)
+
+	require.Equal(t, exitCodeInvalid, code)
+	require.Equal(
+		t,
+		"failed: failed validating manifests from -: invalid Deployment: bad-deployment: "+
+			"Missing required annotations:\n\tapp.uw.systems/description\n",
+		errOut,
+	)
+}
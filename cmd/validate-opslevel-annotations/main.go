@@ -1,122 +1,399 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"sort"
 	"strings"
 
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/serializer/streaming"
-	"k8s.io/apimachinery/pkg/util/yaml"
-	"k8s.io/client-go/kubernetes/scheme"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	// exitCodeInvalid is returned when manifests have invalid annotations
+	exitCodeInvalid = 1
+	// exitCodeError is returned when manifests couldn't be checked, e.g.
+	// because they couldn't be read, even if others have invalid annotations
+	exitCodeError = 2
+)
+
 func main() {
-	if err := validateOpsLevelAnnotationsForManifests(os.Args[1:]); err != nil {
-		fmt.Fprintf(os.Stderr, "failed: %v\n", err)
-		os.Exit(1)
+	os.Exit(run(os.Args, os.Stdout, os.Stderr))
+}
+
+// run runs the app with args, writing its output to stdout and stderr, and
+// returns the code to exit with
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	app := newApp()
+	app.Writer = stdout
+	app.ErrWriter = stderr
+	if err := app.Run(args); err != nil {
+		fmt.Fprintf(stderr, "failed: %v\n", err)
+		return exitCode(err)
 	}
+	return 0
 }
 
-func validateOpsLevelAnnotationsForManifests(manifestFiles []string) error {
-	var errString string
+// newApp returns the app, validating the manifests given as arguments
+func newApp() *cli.App {
+	var rulesFile string
+	var format string
+	var fix bool
+	var exemptionsFile string
+	var baselineFile string
+	var writeBaselineFile bool
+	return &cli.App{
+		Name:            "validate-opslevel-annotations",
+		Usage:           "Check the OpsLevel annotations of Kubernetes manifests",
+		ArgsUsage:       "[manifest-file | directory | -]...",
+		HideHelpCommand: true,
+		Description: "Validates each manifest file given, every file matching '--include' in each " +
+			"directory given, recursively, and manifests read from stdin for '-', e.g. as piped " +
+			"from 'kustomize build . | validate-opslevel-annotations -'",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:        "rules",
+				Usage:       "YAML file of rules to validate against, instead of the defaults",
+				Destination: &rulesFile,
+			},
+			&cli.StringSliceFlag{
+				Name:  "include",
+				Value: cli.NewStringSlice("*.yaml", "*.yml"),
+				Usage: "Pattern matching the names of manifest files to validate in directories. May be given multiple times",
+			},
+			&cli.StringFlag{
+				Name:        "format",
+				Value:       formatText,
+				Usage:       "Format to output findings in, one of 'text', 'json', 'sarif' or 'github'",
+				Destination: &format,
+			},
+			&cli.BoolFlag{
+				Name: "fix",
+				Usage: fmt.Sprintf(
+					"Add missing required annotations to manifest files, with the value '%s' to be replaced by hand, "+
+						"before reporting what's left to fix",
+					fixPlaceholder,
+				),
+				Destination: &fix,
+			},
+			&cli.StringFlag{
+				Name:        "exemptions",
+				Usage:       "YAML file of exemptions waiving findings for objects, by namespace, kind and name, until a date",
+				Destination: &exemptionsFile,
+			},
+			&cli.StringFlag{
+				Name:        "baseline",
+				Usage:       "YAML file of existing findings to ignore, so that only new ones fail",
+				Destination: &baselineFile,
+			},
+			&cli.BoolFlag{
+				Name:        "write-baseline",
+				Usage:       "Write the findings that fail to the '--baseline' file, ignoring them from then on",
+				Destination: &writeBaselineFile,
+			},
+		},
+		Before: func(c *cli.Context) error {
+			if _, ok := findingWriters[format]; !ok && format != formatText {
+				return fmt.Errorf("invalid format '%s'", format)
+			}
+			if writeBaselineFile && baselineFile == "" {
+				return fmt.Errorf("'--write-baseline' needs the '--baseline' file to write")
+			}
+			return nil
+		},
+		Action: func(c *cli.Context) error {
+			rules, err := loadRuleSet(rulesFile)
+			if err != nil {
+				return err
+			}
 
-	for _, manifestFile := range manifestFiles {
-		file, err := os.Open(manifestFile)
-		if err != nil {
-			return fmt.Errorf("Failed opening manifest: %s: %v", manifestFile, err)
-		}
-		defer file.Close()
+			manifestFiles, unsearchable, err := findManifestFiles(c.Args().Slice(), c.StringSlice("include"))
+			if err != nil {
+				return err
+			}
+			if fix && slices.Contains(manifestFiles, stdinArg) {
+				return fmt.Errorf("can't fix manifests read from stdin")
+			}
+			exemptions, err := loadExemptions(exemptionsFile)
+			if err != nil {
+				return err
+			}
 
-		objects, err := decodeManifest(file)
-		if err != nil {
-			return fmt.Errorf("Failed reading manifests from %s: %v", manifestFile, err)
-		}
-
-		var objectErrStrings []string
-		for _, object := range objects {
-			if err := validateOpsLevelAnnotations(object); err != nil {
-				objectErrStrings = append(
-					objectErrStrings,
-					fmt.Sprintf(
-						"invalid %s: %s: %v",
-						object.GetObjectKind().GroupVersionKind().Kind,
-						object.GetName(),
-						err,
-					),
+			var baseline map[baselineEntry]bool
+			if baselineFile != "" && !writeBaselineFile {
+				baseline, err = loadBaseline(baselineFile)
+				if err != nil {
+					return err
+				}
+			}
+
+			var fixedBaselineEntries int
+			check := func() []finding {
+				findings := append(slices.Clone(unsearchable), findingsForManifests(rules, manifestFiles)...)
+				applyExemptions(findings, exemptions)
+				fixedBaselineEntries = applyBaseline(findings, baseline)
+				return findings
+			}
+			findings := check()
+			if failing := failingFindings(findings); fix && len(failing) != 0 {
+				if err := fixManifests(c.App.ErrWriter, failing); err != nil {
+					return err
+				}
+				findings = check()
+			}
+
+			if writeBaselineFile {
+				if err := writeBaseline(baselineFile, findings); err != nil {
+					return err
+				}
+				baseline, err = loadBaseline(baselineFile)
+				if err != nil {
+					return err
+				}
+				fixedBaselineEntries = applyBaseline(findings, baseline)
+			}
+			if fixedBaselineEntries != 0 {
+				fmt.Fprintf(
+					c.App.ErrWriter,
+					"%d findings in baseline %s are no longer found, remove them with '--write-baseline'\n",
+					fixedBaselineEntries,
+					baselineFile,
 				)
 			}
-		}
-		if len(objectErrStrings) != 0 {
-			errString += fmt.Sprintf(
-				"failed validating manifests from %s: %s",
-				manifestFile,
-				strings.Join(objectErrStrings, "\n"),
-			)
+			return reportFindings(c.App.Writer, c.App.ErrWriter, format, findings)
+		},
+	}
+}
+
+// manifestsError reports every finding for manifest files that couldn't be
+// read or failed validation
+type manifestsError struct {
+	// findings for each such file, in the order the files were given
+	findings []finding
+	// reported is set once the findings have been written out in a format
+	// other than text, so they aren't repeated in the error message
+	reported bool
+}
+
+func (e *manifestsError) Error() string {
+	if e.reported {
+		return fmt.Sprintf("found %d problems with manifests", len(e.findings))
+	}
+	return textFindings(e.findings)
+}
+
+// unreadable reports whether any file couldn't be read or decoded
+func (e *manifestsError) unreadable() bool {
+	for _, f := range e.findings {
+		if f.RuleID == ruleIDUnreadable {
+			return true
 		}
 	}
+	return false
+}
 
-	if errString != "" {
-		return errors.New(errString)
+// reportFindings reports findings in format, returning the error to exit with
+// if any haven't been exempted or baselined. Findings in text are reported by
+// the error, so only those exempted, and the number baselined, are written to
+// errW, while other formats write every finding to w
+func reportFindings(w io.Writer, errW io.Writer, format string, findings []finding) error {
+	var manifestsErr *manifestsError
+	if failing := failingFindings(findings); len(failing) != 0 {
+		manifestsErr = &manifestsError{findings: failing}
 	}
 
+	if format == formatText {
+		var baselined int
+		for _, f := range findings {
+			if f.exempted() {
+				fmt.Fprintf(errW, "exempted: %s: %s\n", f.File, f.describe())
+			} else if f.Baselined {
+				baselined++
+			}
+		}
+		if baselined != 0 {
+			fmt.Fprintf(errW, "ignored %d findings in the baseline\n", baselined)
+		}
+	} else {
+		if err := findingWriters[format](w, findings); err != nil {
+			return fmt.Errorf("Failed writing findings: %v", err)
+		}
+		if manifestsErr != nil {
+			manifestsErr.reported = true
+		}
+	}
+
+	if manifestsErr != nil {
+		return manifestsErr
+	}
 	return nil
 }
 
-func validateOpsLevelAnnotations(object client.Object) error {
+// exitCode returns the code to exit with when failing with err, distinguishing
+// manifests with invalid annotations from manifests that couldn't be checked
+func exitCode(err error) int {
+	var manifestsErr *manifestsError
+	if errors.As(err, &manifestsErr) && !manifestsErr.unreadable() {
+		return exitCodeInvalid
+	}
+	return exitCodeError
+}
+
+// findingsForManifests validates every manifest file, returning all findings,
+// including those exempted by annotations
+func findingsForManifests(rules ruleSet, manifestFiles []string) []finding {
+	var findings []finding
+	for _, manifestFile := range manifestFiles {
+		findings = append(findings, validateManifestFile(rules, manifestFile)...)
+	}
+	return findings
+}
+
+// validateManifestFile validates every object in a manifest file, returning
+// a finding for each way an object is invalid, or a single finding if the file
+// can't be read or decoded
+func validateManifestFile(rules ruleSet, manifestFile string) []finding {
+	unreadable := func(err error) []finding {
+		return []finding{{File: manifestFile, RuleID: ruleIDUnreadable, Message: err.Error()}}
+	}
+
+	file, err := openManifest(manifestFile)
+	if err != nil {
+		return unreadable(fmt.Errorf("Failed opening manifest: %s: %v", manifestFile, err))
+	}
+	defer file.Close()
+
+	objects, err := decodeManifest(file, rules)
+	if err != nil {
+		return unreadable(fmt.Errorf("Failed reading manifests from %s: %v", manifestFile, err))
+	}
+
+	var findings []finding
+	for _, object := range objects {
+		for _, f := range validateOpsLevelAnnotations(object, rules) {
+			f.File = manifestFile
+			f.DocumentIndex = object.document
+			f.DocumentLine = object.positions.document.line
+			position := object.positions.annotations
+			if keyPosition, ok := object.positions.annotationKeys[f.Annotation]; ok &&
+				(f.RuleID == ruleIDAnnotationValue || f.RuleID == ruleIDInvalidExemption) {
+				position = keyPosition
+			}
+			f.Line, f.Column = position.line, position.column
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// validateOpsLevelAnnotations returns a finding for each way the annotations
+// of object don't meet rules, without the file or document it's from set
+func validateOpsLevelAnnotations(object client.Object, rules ruleSet) []finding {
 	annotations := object.GetAnnotations()
 
-	if checkIfIsComponent(annotations) {
-		// skip components
+	if matchesAny(rules.Skip, annotations) {
+		// skip e.g. components
 		return nil
 	}
-	missingRequiredAnnotations := getMissingRequiredAnnotations(annotations)
-	missingPrefixAnnotations := getMissingRequiredPrefixAnnotations(annotations)
 
-	var errStrings []string
-	if len(missingRequiredAnnotations) != 0 {
-		errStrings = append(
-			errStrings,
-			fmt.Sprintf(
-				"Missing required annotations:\n\t%s",
-				strings.Join(missingRequiredAnnotations, "\n\t"),
+	gvk := object.GetObjectKind().GroupVersionKind()
+	var missingRequiredAnnotations []string
+	var missingPrefixAnnotations []string
+	var invalidValues []invalidValue
+	placeholders := map[string]bool{}
+	for _, rule := range rules.Rules {
+		if !rule.appliesTo(gvk, annotations) {
+			continue
+		}
+		for name, value := range annotations {
+			if value == fixPlaceholder && rule.covers(name) {
+				placeholders[name] = true
+			}
+		}
+		missingRequiredAnnotations = append(
+			missingRequiredAnnotations,
+			getMissingRequiredAnnotations(annotations, rule.Required)...,
+		)
+		missingPrefixAnnotations = append(
+			missingPrefixAnnotations,
+			getMissingRequiredPrefixAnnotations(annotations, rule.RequiredPrefixes)...,
+		)
+		invalidValues = append(invalidValues, getInvalidValues(annotations, rule.Values)...)
+	}
+	invalidValues = withPlaceholderValues(invalidValues, placeholders)
+	// sort for a consistent output
+	sort.Strings(missingPrefixAnnotations)
+
+	objectFinding := func(ruleID string, annotation string, message string) finding {
+		return finding{
+			Kind:       gvk.Kind,
+			Name:       object.GetName(),
+			Namespace:  object.GetNamespace(),
+			RuleID:     ruleID,
+			Annotation: annotation,
+			Message:    message,
+		}
+	}
+	var findings []finding
+	for _, annotation := range missingRequiredAnnotations {
+		findings = append(
+			findings,
+			objectFinding(
+				ruleIDRequiredAnnotation,
+				annotation,
+				"Missing required annotation: "+annotation,
 			),
 		)
 	}
-	if len(missingPrefixAnnotations) != 0 {
-		errStrings = append(
-			errStrings,
-			fmt.Sprintf(
-				"No annotation found with required prefixes:\n\t%s",
-				strings.Join(missingPrefixAnnotations, "\n\t"),
+	for _, prefix := range missingPrefixAnnotations {
+		findings = append(
+			findings,
+			objectFinding(
+				ruleIDRequiredPrefix,
+				prefix,
+				"No annotation found with required prefix: "+prefix,
 			),
 		)
 	}
-
-	if len(errStrings) != 0 {
-		return errors.New(strings.Join(errStrings, "\n"))
+	for _, invalid := range invalidValues {
+		findings = append(
+			findings,
+			objectFinding(ruleIDAnnotationValue, invalid.annotation, invalid.reason),
+		)
 	}
-	return nil
-}
 
-func checkIfIsComponent(annotations map[string]string) bool {
-	componentAnnotation := "app.uw.systems/is-component"
-	if isComponentStr, ok := annotations[componentAnnotation]; ok {
-		return isComponentStr == "true"
+	if value, ok := annotations[rules.ExemptionAnnotation]; ok && rules.ExemptionAnnotation != "" {
+		exemptions, reason := parseExemptions(rules.ExemptionAnnotation, value)
+		if reason != "" {
+			findings = append(
+				findings,
+				objectFinding(ruleIDInvalidExemption, rules.ExemptionAnnotation, reason),
+			)
+		}
+		for i := range findings {
+			for _, e := range exemptions {
+				if e.appliesTo(findings[i].Annotation) {
+					e.inSource = true
+					findings[i].exempt(e)
+				}
+			}
+		}
 	}
-	return false
+	return findings
 }
 
-func getMissingRequiredAnnotations(annotations map[string]string) []string {
-	requiredAnnotations := []string{
-		"app.uw.systems/description",
-		"app.uw.systems/tier",
-	}
-
+func getMissingRequiredAnnotations(
+	annotations map[string]string,
+	requiredAnnotations []string,
+) []string {
 	var missingAnnotations []string
 	for _, requiredAnnotation := range requiredAnnotations {
 		if _, ok := annotations[requiredAnnotation]; !ok {
@@ -127,16 +404,13 @@ func getMissingRequiredAnnotations(annotations map[string]string) []string {
 	return missingAnnotations
 }
 
-func getMissingRequiredPrefixAnnotations(annotations map[string]string) []string {
-	seenPrefixAnnotations := map[string]bool{"app.uw.systems/repos": false}
-
-	for _, skipTag := range []string{
-		"oss",
-		"skip-repo",
-	} {
-		if _, exists := annotations["app.uw.systems/tags."+skipTag]; exists {
-			return nil
-		}
+func getMissingRequiredPrefixAnnotations(
+	annotations map[string]string,
+	requiredPrefixes []string,
+) []string {
+	seenPrefixAnnotations := map[string]bool{}
+	for _, prefix := range requiredPrefixes {
+		seenPrefixAnnotations[prefix] = false
 	}
 
 	for annotation := range annotations {
@@ -159,39 +433,135 @@ func getMissingRequiredPrefixAnnotations(annotations map[string]string) []string
 	return missingAnnotations
 }
 
-// decodeManifests decodes a manifest file containing manifests for 1 or more
-// k8s objects
-func decodeManifest(rc io.ReadCloser) ([]client.Object, error) {
-	yamlDecoder := yaml.NewDocumentDecoder(rc)
-	streamDecoder := streaming.NewDecoder(yamlDecoder, scheme.Codecs.UniversalDeserializer())
-	defer streamDecoder.Close()
-
-	var objects []client.Object
-	for {
-		object, _, err := streamDecoder.Decode(nil, nil)
-		if err != nil {
-			switch {
-			case err == io.EOF:
-				return objects, nil
-			case runtime.IsNotRegisteredError(err):
-				// we don't want to try and cover all possible APIs
-				// we're only interested in a handful of builtin ones
+// invalidValue is an annotation value that doesn't meet a value rule
+type invalidValue struct {
+	annotation string
+	// reason describes the value and what was expected instead
+	reason string
+}
+
+// getInvalidValues returns each annotation value not meeting the value rules,
+// and why it fails them
+func getInvalidValues(annotations map[string]string, valueRules []valueRule) []invalidValue {
+	// sort for a consistent output
+	names := make([]string, 0, len(annotations))
+	for name := range annotations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var invalidValues []invalidValue
+	for _, valueRule := range valueRules {
+		for _, name := range names {
+			if !valueRule.appliesTo(name) {
 				continue
-			default:
-				return nil, err
+			}
+			if reason := valueRule.check(name, annotations[name]); reason != "" {
+				invalidValues = append(invalidValues, invalidValue{annotation: name, reason: reason})
 			}
 		}
+	}
+	return invalidValues
+}
+
+// withPlaceholderValues returns invalidValues with the placeholders, values
+// left as added by '--fix', reported first instead of any other reason their
+// value is invalid, so that they keep failing until they're replaced
+func withPlaceholderValues(invalidValues []invalidValue, placeholders map[string]bool) []invalidValue {
+	// sort for a consistent output
+	names := make([]string, 0, len(placeholders))
+	for name := range placeholders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-		if isSupportedObject(object) {
-			objects = append(objects, object.(client.Object))
+	var withPlaceholders []invalidValue
+	for _, name := range names {
+		withPlaceholders = append(withPlaceholders, invalidValue{
+			annotation: name,
+			reason:     fmt.Sprintf("%s: %q, a placeholder added by '--fix' to be replaced", name, fixPlaceholder),
+		})
+	}
+	for _, invalid := range invalidValues {
+		if !placeholders[invalid.annotation] {
+			withPlaceholders = append(withPlaceholders, invalid)
 		}
 	}
+	return withPlaceholders
+}
+
+// manifestObject is an object decoded from a manifest file
+type manifestObject struct {
+	client.Object
+	// document is the index of the YAML document the object was decoded
+	// from, starting at 0
+	document int
+	// positions are where parts of the object are in the manifest file
+	positions objectPositions
 }
 
-func isSupportedObject(object runtime.Object) bool {
-	kind := object.GetObjectKind().GroupVersionKind().Kind
-	group := object.GetObjectKind().GroupVersionKind().Group
+// decodeManifests decodes a manifest file containing manifests for 1 or more
+// k8s objects, returning those of the kinds validated by rules. Objects are
+// decoded as unstructured, so that custom resources can be validated too
+func decodeManifest(r io.Reader, rules ruleSet) ([]manifestObject, error) {
+	decoder := yaml.NewDecoder(r)
 
-	return (group == "batch" && kind == "CronJob") ||
-		(group == "apps" && (kind == "Deployment" || kind == "StatefulSet"))
+	var objects []manifestObject
+	for document := 1; ; document++ {
+		var node yaml.Node
+		if err := decoder.Decode(&node); err != nil {
+			if err == io.EOF {
+				return objects, nil
+			}
+			return nil, err
+		}
+		if len(node.Content) == 0 {
+			// empty document
+			continue
+		}
+		var decoded map[string]interface{}
+		if err := node.Content[0].Decode(&decoded); err != nil {
+			return nil, err
+		}
+		if decoded == nil {
+			// document of only comments
+			continue
+		}
+		content, err := unstructuredContent(decoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid document %d: %v", document, err)
+		}
+
+		object := &unstructured.Unstructured{Object: content}
+		if object.GetKind() == "" {
+			return nil, fmt.Errorf("Object 'Kind' is missing in document %d", document)
+		}
+		if !rules.validates(object.GroupVersionKind()) {
+			continue
+		}
+		// annotations that aren't all strings are otherwise silently dropped
+		if _, _, err := unstructured.NestedStringMap(content, "metadata", "annotations"); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s: %v", object.GetKind(), object.GetName(), err)
+		}
+		objects = append(objects, manifestObject{
+			Object:    object,
+			document:  document - 1,
+			positions: findPositions(node.Content[0]),
+		})
+	}
+}
+
+// unstructuredContent converts content decoded from YAML into the types
+// unstructured objects hold, by round-tripping it through JSON, as YAML decodes
+// numbers as ints, which unstructured objects can't be deep copied with
+func unstructuredContent(content map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	var converted map[string]interface{}
+	if err := utiljson.Unmarshal(data, &converted); err != nil {
+		return nil, err
+	}
+	return converted, nil
 }
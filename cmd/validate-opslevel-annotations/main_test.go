@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 // 'testdata' contains two directories:
@@ -25,16 +27,33 @@ func init() {
 	testdataDir = filepath.Join(filepath.Dir(file), "testdata")
 }
 
+// runApp runs the app with args, returning its exit code and what it wrote to
+// stdout and stderr
+func runApp(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(append([]string{"validate-opslevel-annotations"}, args...), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func defaultRuleSet(t *testing.T) ruleSet {
+	t.Helper()
+	rules, err := loadRuleSet("")
+	require.NoError(t, err)
+	return rules
+}
+
 func TestFailsOnUnreadableFile(t *testing.T) {
 	unreadableFile := filepath.Join(t.TempDir(), "unreadable")
 	f, err := os.Create(unreadableFile)
 	require.NoError(t, err)
 	require.NoError(t, f.Chmod(0o200))
-	expectedErrPrefix := "Failed opening manifest: " + unreadableFile
+	expectedErrPrefix := "failed: Failed opening manifest: " + unreadableFile
 
-	err = validateOpsLevelAnnotationsForManifests([]string{unreadableFile})
+	code, _, errOut := runApp(t, unreadableFile)
 
-	require.ErrorContains(t, err, expectedErrPrefix)
+	require.Equal(t, exitCodeError, code)
+	require.True(t, strings.HasPrefix(errOut, expectedErrPrefix), errOut)
 }
 
 func TestFailsOnUnparseableManifest(t *testing.T) {
@@ -45,11 +64,85 @@ func TestFailsOnUnparseableManifest(t *testing.T) {
 `
 	manifestPath := filepath.Join(t.TempDir(), "deployment.yaml")
 	require.NoError(t, os.WriteFile(manifestPath, []byte(invalidManifest), 0o600))
-	expectedErrPrefix := "Failed reading manifests from " + manifestPath
+	expectedErrPrefix := "failed: Failed reading manifests from " + manifestPath
+
+	code, _, errOut := runApp(t, manifestPath)
+
+	require.Equal(t, exitCodeError, code)
+	require.True(t, strings.HasPrefix(errOut, expectedErrPrefix), errOut)
+}
+
+func TestReportsEveryFile(t *testing.T) {
+	missingFile := filepath.Join(t.TempDir(), "missing.yaml")
+	invalidManifest := filepath.Join(testdataDir, "invalid", "SingleDeploymentMissingDescription.yaml")
+	validManifest := filepath.Join(testdataDir, "valid", "SingleDeployment.yaml")
+
+	code, out, errOut := runApp(t, missingFile, invalidManifest, validManifest)
+
+	require.Equal(t, exitCodeError, code)
+	require.Empty(t, out)
+	lines := strings.Split(strings.TrimSuffix(errOut, "\n"), "\n")
+	require.Len(t, lines, 3)
+	require.True(t, strings.HasPrefix(lines[0], "failed: Failed opening manifest: "+missingFile))
+	require.Equal(
+		t,
+		"failed validating manifests from "+invalidManifest+": invalid Deployment: bad-deployment: "+
+			"Missing required annotations:",
+		lines[1],
+	)
+}
+
+func TestExitCodes(t *testing.T) {
+	validManifest := filepath.Join(testdataDir, "valid", "SingleDeployment.yaml")
+	code, _, _ := runApp(t, validManifest)
+	require.Equal(t, 0, code)
+
+	invalidManifest := filepath.Join(testdataDir, "invalid", "SingleDeploymentMissingAll.yaml")
+	code, _, _ = runApp(t, invalidManifest)
+	require.Equal(t, exitCodeInvalid, code)
+
+	code, _, _ = runApp(t, "--rules", filepath.Join(t.TempDir(), "missing.yaml"), invalidManifest)
+	require.Equal(t, exitCodeError, code)
+}
+
+func TestFailsOnNonStringAnnotations(t *testing.T) {
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-deployment
+  annotations:
+    app.uw.systems/tier: 4
+`
+	manifestPath := filepath.Join(t.TempDir(), "deployment.yaml")
+	require.NoError(t, os.WriteFile(manifestPath, []byte(manifest), 0o600))
+	expectedErrPrefix := "failed: Failed reading manifests from " + manifestPath +
+		": invalid Deployment: my-deployment:"
+
+	code, _, errOut := runApp(t, manifestPath)
+
+	require.Equal(t, exitCodeError, code)
+	require.True(t, strings.HasPrefix(errOut, expectedErrPrefix), errOut)
+}
+
+func TestDecodesNumbersForDeepCopy(t *testing.T) {
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-deployment
+spec:
+  replicas: 3
+  progressDeadlineSeconds: 1.5
+`
 
-	err := validateOpsLevelAnnotationsForManifests([]string{manifestPath})
+	objects, err := decodeManifest(strings.NewReader(manifest), defaultRuleSet(t))
 
-	require.ErrorContains(t, err, expectedErrPrefix)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	// unstructured objects panic when deep copying ints
+	copied := objects[0].DeepCopyObject().(*unstructured.Unstructured)
+	replicas, _, err := unstructured.NestedInt64(copied.Object, "spec", "replicas")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), replicas)
 }
 
 func TestFailsOnInvalidManifests(t *testing.T) {
@@ -66,19 +159,16 @@ func TestFailsOnInvalidManifests(t *testing.T) {
 				expectedOutFile := strings.Replace(entry.Name(), ".yaml", ".out", 1)
 				expectedOut, err := os.ReadFile(filepath.Join(invalidManifestsDir, expectedOutFile))
 				require.NoErrorf(t, err, "Can't find output file for %s", entry.Name())
-				// .out files contain trailing newlines, but the error will not
-				expectedErr := strings.TrimSuffix(
-					fmt.Sprintf(
-						"failed validating manifests from %s: %s",
-						manifest,
-						string(expectedOut),
-					),
-					"\n",
+				expectedErrOut := fmt.Sprintf(
+					"failed: failed validating manifests from %s: %s",
+					manifest,
+					string(expectedOut),
 				)
 
-				gotErr := validateOpsLevelAnnotationsForManifests([]string{manifest})
-				require.Error(t, gotErr)
-				require.EqualError(t, gotErr, expectedErr)
+				code, out, errOut := runApp(t, manifest)
+				require.Equal(t, exitCodeInvalid, code)
+				require.Empty(t, out)
+				require.Equal(t, expectedErrOut, errOut)
 			})
 		}
 	}
@@ -92,7 +182,156 @@ func TestAcceptsValidManifests(t *testing.T) {
 	for _, entry := range entries {
 		manifest := filepath.Join(validManifestsDir, entry.Name())
 		t.Run(entry.Name(), func(t *testing.T) {
-			require.NoError(t, validateOpsLevelAnnotationsForManifests([]string{manifest}))
+			code, out, errOut := runApp(t, manifest)
+			require.Equal(t, 0, code)
+			require.Empty(t, out)
+			require.Empty(t, errOut)
+		})
+	}
+}
+
+func TestValidatesAgainstRulesFile(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.yaml")
+	rules := `kinds:
+  - group: apps
+    kind: Deployment
+  - group: batch
+    kind: CronJob
+  - group: keda.sh
+    kind: ScaledJob
+rules:
+  - kinds:
+      - group: apps
+        kind: Deployment
+      - group: keda.sh
+        kind: ScaledJob
+    required:
+      - example.com/owner
+    requiredPrefixes:
+      - example.com/links
+    skip:
+      - annotation: example.com/unowned
+        value: "true"
+`
+	require.NoError(t, os.WriteFile(rulesFile, []byte(rules), 0o600))
+	manifest := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: bad-deployment
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: unowned-deployment
+  annotations:
+    example.com/unowned: "true"
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: other-cronjob
+---
+apiVersion: keda.sh/v1alpha1
+kind: ScaledJob
+metadata:
+  name: bad-scaledjob
+  annotations:
+    example.com/owner: someone
+`
+	manifestPath := filepath.Join(t.TempDir(), "manifests.yaml")
+	require.NoError(t, os.WriteFile(manifestPath, []byte(manifest), 0o600))
+
+	code, _, errOut := runApp(t, "--rules", rulesFile, manifestPath)
+
+	require.Equal(t, exitCodeInvalid, code)
+	require.Equal(
+		t,
+		"failed: failed validating manifests from "+manifestPath+": invalid Deployment: bad-deployment: "+
+			"Missing required annotations:\n\texample.com/owner\n"+
+			"No annotation found with required prefixes:\n\texample.com/links\n"+
+			"invalid ScaledJob: bad-scaledjob: "+
+			"No annotation found with required prefixes:\n\texample.com/links\n",
+		errOut,
+	)
+}
+
+func TestFailsOnInvalidRulesFile(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(rulesFile, []byte("rules:\n  - require: []\n"), 0o600))
+
+	_, err := loadRuleSet(rulesFile)
+
+	require.ErrorContains(t, err, "Failed parsing rules from "+rulesFile)
+}
+
+func TestFailsOnRulesFileWithoutKinds(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(
+		t,
+		os.WriteFile(rulesFile, []byte("rules:\n  - required: [app.uw.systems/description]\n"), 0o600),
+	)
+
+	_, err := loadRuleSet(rulesFile)
+
+	require.EqualError(
+		t,
+		err,
+		"Failed parsing rules from "+rulesFile+": no kinds to validate, 'kinds' must list at least one",
+	)
+}
+
+func TestValueRuleCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     valueRule
+		value    string
+		expected string
+	}{
+		{
+			name:  "in enum",
+			rule:  valueRule{Enum: []string{"tier_1", "tier_2"}},
+			value: "tier_2",
+		},
+		{
+			name:     "not in enum",
+			rule:     valueRule{Enum: []string{"tier_1", "tier_2"}},
+			value:    "tier_3",
+			expected: `example.com/key: "tier_3", expected one of: tier_1, tier_2`,
+		},
+		{
+			name:     "only whitespace",
+			rule:     valueRule{MinLength: 1, MaxLength: 5},
+			value:    " \t",
+			expected: `example.com/key: " \t", expected at least 1 characters`,
+		},
+		{
+			name:     "too long",
+			rule:     valueRule{MinLength: 1, MaxLength: 5},
+			value:    "too long",
+			expected: "example.com/key: 8 characters, expected at most 5",
+		},
+		{
+			name:  "allowed URL",
+			rule:  valueRule{URLHosts: []string{"github.com"}},
+			value: "https://github.com/utilitywarehouse/manifest-checkers",
+		},
+		{
+			name:     "URL to other host",
+			rule:     valueRule{URLHosts: []string{"github.com"}},
+			value:    "https://gitlab.com/utilitywarehouse/manifest-checkers",
+			expected: `example.com/key: "https://gitlab.com/utilitywarehouse/manifest-checkers", expected an https URL to: github.com`,
+		},
+		{
+			name:     "not a URL",
+			rule:     valueRule{URLHosts: []string{"github.com"}},
+			value:    "manifest-checkers",
+			expected: `example.com/key: "manifest-checkers", expected an https URL to: github.com`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.rule.check("example.com/key", tt.value))
 		})
 	}
 }
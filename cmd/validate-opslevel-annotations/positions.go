@@ -0,0 +1,84 @@
+package main
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// position is a line and column in a manifest file, both starting at 1
+type position struct {
+	line   int
+	column int
+}
+
+// nodePosition returns the position of node
+func nodePosition(node *yaml.Node) position {
+	return position{line: node.Line, column: node.Column}
+}
+
+// objectPositions are the positions of the parts of an object's manifest that
+// findings are reported at
+type objectPositions struct {
+	// document is the start of the object's manifest
+	document position
+	// annotations is the 'metadata.annotations' key, or where it'd be added
+	// if there is none: the 'metadata' key, or else the start of the manifest
+	annotations position
+	// annotationKeys are the keys of each annotation
+	annotationKeys map[string]position
+}
+
+// findPositions returns the positions of the parts of the object decoded from
+// node, the content of a YAML document
+func findPositions(node *yaml.Node) objectPositions {
+	positions := objectPositions{
+		document:       nodePosition(node),
+		annotations:    nodePosition(node),
+		annotationKeys: map[string]position{},
+	}
+
+	metadataKey, metadata := mappingValue(node, "metadata")
+	if metadataKey == nil {
+		return positions
+	}
+	positions.annotations = nodePosition(metadataKey)
+
+	annotationsKey, annotations := mappingValue(metadata, "annotations")
+	if annotationsKey == nil {
+		return positions
+	}
+	positions.annotations = nodePosition(annotationsKey)
+
+	annotations = resolveAlias(annotations)
+	if annotations.Kind != yaml.MappingNode {
+		return positions
+	}
+	for i := 0; i+1 < len(annotations.Content); i += 2 {
+		key := annotations.Content[i]
+		positions.annotationKeys[key.Value] = nodePosition(key)
+	}
+	return positions
+}
+
+// mappingValue returns the key and value nodes of key in node, a mapping, or
+// nil if node isn't a mapping or doesn't have the key
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	node = resolveAlias(node)
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// resolveAlias returns the node an alias, e.g. '*app', refers to, or node
+// itself if it isn't an alias
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindingPositions(t *testing.T) {
+	manifest := `# a comment
+apiVersion: apps/v1
+kind: Deployment
+metadata: &metadata
+  name: no-annotations
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: invalid-tier
+  annotations:
+    app.uw.systems/description: Some service
+    app.uw.systems/repos.some-service: https://github.com/utilitywarehouse/some-service
+    app.uw.systems/tier: high
+---
+---
+apiVersion: batch/v1
+kind: CronJob
+spec:
+  schedule: "@daily"
+`
+	manifestPath := filepath.Join(t.TempDir(), "manifests.yaml")
+	require.NoError(t, os.WriteFile(manifestPath, []byte(manifest), 0o600))
+
+	var positions []position
+	for _, f := range validateManifestFile(defaultRuleSet(t), manifestPath) {
+		positions = append(positions, position{line: f.Line, column: f.Column})
+		require.NotZero(t, f.DocumentLine)
+	}
+
+	require.Equal(
+		t,
+		[]position{
+			// missing annotations are reported at 'metadata'
+			{line: 4, column: 1},
+			{line: 4, column: 1},
+			{line: 4, column: 1},
+			// invalid values at the annotation
+			{line: 14, column: 5},
+			// or the start of the object without metadata
+			{line: 17, column: 1},
+			{line: 17, column: 1},
+			{line: 17, column: 1},
+		},
+		positions,
+	)
+}
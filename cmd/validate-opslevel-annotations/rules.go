@@ -0,0 +1,191 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+//go:embed default-rules.yaml
+var defaultRules []byte
+
+// ruleSet describes which objects are validated and the annotations they need
+type ruleSet struct {
+	// Kinds are the kinds of objects validated
+	Kinds []groupKind `yaml:"kinds"`
+	// Skip lists conditions for objects not to be validated at all
+	Skip  []condition `yaml:"skip"`
+	Rules []rule      `yaml:"rules"`
+	// ExemptionAnnotation is the annotation objects can be exempted from
+	// rules with, until a date
+	ExemptionAnnotation string `yaml:"exemptionAnnotation"`
+}
+
+// groupKind identifies a kind of object, regardless of API version
+type groupKind struct {
+	Group string `yaml:"group"`
+	Kind  string `yaml:"kind"`
+}
+
+// rule requires annotations of the objects it applies to
+type rule struct {
+	// Kinds are the kinds of objects the rule applies to, or all validated
+	// kinds if empty
+	Kinds []groupKind `yaml:"kinds"`
+	// Required lists annotations that must be set
+	Required []string `yaml:"required"`
+	// RequiredPrefixes lists prefixes that at least one annotation must
+	// start with
+	RequiredPrefixes []string `yaml:"requiredPrefixes"`
+	// Values lists constraints on the values of annotations, when set
+	Values []valueRule `yaml:"values"`
+	// Skip lists conditions for objects the rule doesn't apply to
+	Skip []condition `yaml:"skip"`
+}
+
+// valueRule constrains the value of an annotation, or of every annotation with
+// a prefix
+type valueRule struct {
+	Annotation string `yaml:"annotation"`
+	Prefix     string `yaml:"prefix"`
+	// Enum lists the allowed values
+	Enum []string `yaml:"enum"`
+	// MinLength is the minimum number of characters, not counting leading
+	// and trailing whitespace, so that e.g. " " isn't taken as non-empty
+	MinLength int `yaml:"minLength"`
+	MaxLength int `yaml:"maxLength"`
+	// URLHosts requires values to be https URLs to one of the hosts
+	URLHosts []string `yaml:"urlHosts"`
+}
+
+// condition matches objects with an annotation, set to Value if given
+type condition struct {
+	Annotation string  `yaml:"annotation"`
+	Value      *string `yaml:"value"`
+}
+
+// loadRuleSet reads a rule set from a YAML file, or returns the default rules
+// if filename is empty
+func loadRuleSet(filename string) (ruleSet, error) {
+	if filename == "" {
+		return parseRuleSet(defaultRules)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return ruleSet{}, fmt.Errorf("Failed reading rules: %s: %v", filename, err)
+	}
+	rules, err := parseRuleSet(data)
+	if err != nil {
+		return ruleSet{}, fmt.Errorf("Failed parsing rules from %s: %v", filename, err)
+	}
+	return rules, nil
+}
+
+func parseRuleSet(data []byte) (ruleSet, error) {
+	var rules ruleSet
+	if err := yaml.UnmarshalStrict(data, &rules); err != nil {
+		return ruleSet{}, err
+	}
+	// without any kinds nothing would be validated, so every manifest would
+	// pass, which is almost certainly a mistake
+	if len(rules.Kinds) == 0 {
+		return ruleSet{}, errors.New("no kinds to validate, 'kinds' must list at least one")
+	}
+	return rules, nil
+}
+
+// validates checks whether objects of the given kind are validated
+func (r ruleSet) validates(gvk schema.GroupVersionKind) bool {
+	return slices.Contains(r.Kinds, groupKind{Group: gvk.Group, Kind: gvk.Kind})
+}
+
+// appliesTo checks whether the rule applies to an object of the given kind
+// with the given annotations
+func (r rule) appliesTo(gvk schema.GroupVersionKind, annotations map[string]string) bool {
+	if len(r.Kinds) != 0 && !slices.Contains(r.Kinds, groupKind{Group: gvk.Group, Kind: gvk.Kind}) {
+		return false
+	}
+	return !matchesAny(r.Skip, annotations)
+}
+
+// covers checks whether the rule requires the annotation, one of its prefixes,
+// or constrains its value
+func (r rule) covers(annotation string) bool {
+	if slices.Contains(r.Required, annotation) {
+		return true
+	}
+	for _, prefix := range r.RequiredPrefixes {
+		if strings.HasPrefix(annotation, prefix) {
+			return true
+		}
+	}
+	return slices.ContainsFunc(r.Values, func(v valueRule) bool { return v.appliesTo(annotation) })
+}
+
+// appliesTo checks whether the value rule applies to the annotation
+func (v valueRule) appliesTo(annotation string) bool {
+	if v.Prefix != "" {
+		return strings.HasPrefix(annotation, v.Prefix)
+	}
+	return annotation == v.Annotation
+}
+
+// check returns why the value of the annotation doesn't meet the rule, or ""
+// if it does
+func (v valueRule) check(annotation string, value string) string {
+	if len(v.Enum) != 0 && !slices.Contains(v.Enum, value) {
+		return fmt.Sprintf(
+			"%s: %q, expected one of: %s",
+			annotation,
+			value,
+			strings.Join(v.Enum, ", "),
+		)
+	}
+	if utf8.RuneCountInString(strings.TrimSpace(value)) < v.MinLength {
+		return fmt.Sprintf(
+			"%s: %q, expected at least %d characters",
+			annotation,
+			value,
+			v.MinLength,
+		)
+	} else if length := utf8.RuneCountInString(value); v.MaxLength > 0 && length > v.MaxLength {
+		return fmt.Sprintf(
+			"%s: %d characters, expected at most %d",
+			annotation,
+			length,
+			v.MaxLength,
+		)
+	}
+	if len(v.URLHosts) != 0 {
+		parsed, err := url.Parse(value)
+		if err != nil || parsed.Scheme != "https" || !slices.Contains(v.URLHosts, parsed.Host) {
+			return fmt.Sprintf(
+				"%s: %q, expected an https URL to: %s",
+				annotation,
+				value,
+				strings.Join(v.URLHosts, ", "),
+			)
+		}
+	}
+	return ""
+}
+
+// matchesAny checks whether the annotations match any of the conditions
+func matchesAny(conditions []condition, annotations map[string]string) bool {
+	for _, condition := range conditions {
+		value, ok := annotations[condition.Annotation]
+		if ok && (condition.Value == nil || *condition.Value == value) {
+			return true
+		}
+	}
+	return false
+}
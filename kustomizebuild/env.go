@@ -0,0 +1,119 @@
+package kustomizebuild
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultPassEnv lists the variables of the current environment passed to
+// `kustomize build`, which it needs to find plugins and fetch remote resources
+var DefaultPassEnv = []string{
+	"HOME",
+	"PATH",
+	"TMPDIR",
+	"USER",
+	"XDG_CACHE_HOME",
+	"XDG_CONFIG_HOME",
+	"KUSTOMIZE_PLUGIN_HOME",
+	"SSH_AUTH_SOCK",
+	"HTTP_PROXY",
+	"HTTPS_PROXY",
+	"NO_PROXY",
+	"http_proxy",
+	"https_proxy",
+	"no_proxy",
+}
+
+// EnvConfig configures the environment `kustomize build` runs with. Other than
+// the variables in DefaultPassEnv and PassEnv, the current environment isn't
+// passed on, so that e.g. credentials in a developer's shell can't leak into
+// the built manifests via exec plugins
+type EnvConfig struct {
+	// PassEnv lists further variables of the current environment to pass on
+	PassEnv []string `yaml:"passEnv"`
+	// Rules set variables for building particular kustomizations, later rules
+	// taking precedence
+	Rules []EnvRule `yaml:"rules"`
+}
+
+// EnvRule sets environment variables for building the kustomizations under
+// directories matching Pattern
+type EnvRule struct {
+	// Pattern matches directories relative to the repo root, as for
+	// path.Match. It applies to kustomization roots that are, or are under, a
+	// matching directory
+	Pattern string            `yaml:"pattern"`
+	Env     map[string]string `yaml:"env"`
+}
+
+// LoadEnvConfig reads an EnvConfig from a YAML file
+func LoadEnvConfig(filename string) (EnvConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return EnvConfig{}, fmt.Errorf("error reading environment config '%s': %v", filename, err)
+	}
+
+	var config EnvConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return EnvConfig{}, fmt.Errorf("error parsing environment config '%s': %v", filename, err)
+	}
+	for _, rule := range config.Rules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return EnvConfig{}, fmt.Errorf(
+				"invalid pattern '%s' in environment config '%s': %v",
+				rule.Pattern,
+				filename,
+				err,
+			)
+		}
+	}
+	return config, nil
+}
+
+// environ returns the environment for building the given kustomization root,
+// as 'key=value' pairs
+func (c EnvConfig) environ(kustomizationRoot string) []string {
+	vars := map[string]string{}
+	for _, name := range append(slices.Clone(DefaultPassEnv), c.PassEnv...) {
+		if value, ok := os.LookupEnv(name); ok {
+			vars[name] = value
+		}
+	}
+	for _, rule := range c.Rules {
+		if rule.matches(kustomizationRoot) {
+			for name, value := range rule.Env {
+				vars[name] = value
+			}
+		}
+	}
+
+	env := make([]string, 0, len(vars))
+	for name, value := range vars {
+		env = append(env, name+"="+value)
+	}
+	// sort for a consistent environment
+	slices.Sort(env)
+	return env
+}
+
+// matches checks whether the kustomization root, or any directory it's under,
+// matches the rule's pattern
+func (r EnvRule) matches(kustomizationRoot string) bool {
+	dir := filepath.ToSlash(kustomizationRoot)
+	for {
+		if matched, _ := path.Match(r.Pattern, dir); matched {
+			return true
+		}
+		i := strings.LastIndex(dir, "/")
+		if i < 0 {
+			return false
+		}
+		dir = dir[:i]
+	}
+}
@@ -0,0 +1,123 @@
+package kustomizebuild
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnviron(t *testing.T) {
+	t.Setenv("HOME", "/home/someone")
+	t.Setenv("AWS_PROFILE", "dev")
+	t.Setenv("GITHUB_TOKEN", "secret")
+	config := EnvConfig{
+		PassEnv: []string{"AWS_PROFILE"},
+		Rules: []EnvRule{
+			{Pattern: "clusters/*", Env: map[string]string{"ENVIRONMENT": "dev"}},
+			{
+				Pattern: "clusters/prod-*",
+				Env:     map[string]string{"CLUSTER": "prod", "ENVIRONMENT": "prod"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		root       string
+		expected   []string
+		unexpected []string
+	}{
+		{
+			name:       "no matching rules",
+			root:       "other/app",
+			expected:   []string{"AWS_PROFILE=dev", "HOME=/home/someone"},
+			unexpected: []string{"ENVIRONMENT=dev"},
+		},
+		{
+			name: "matching rule",
+			root: "clusters/dev-aws/app",
+			expected: []string{
+				"AWS_PROFILE=dev",
+				"ENVIRONMENT=dev",
+				"HOME=/home/someone",
+			},
+		},
+		{
+			name: "later rules take precedence",
+			root: "clusters/prod-aws/app",
+			expected: []string{
+				"AWS_PROFILE=dev",
+				"CLUSTER=prod",
+				"ENVIRONMENT=prod",
+				"HOME=/home/someone",
+			},
+			unexpected: []string{"ENVIRONMENT=dev"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := config.environ(tt.root)
+			for _, expected := range tt.expected {
+				require.Contains(t, env, expected)
+			}
+			require.NotContains(t, env, "GITHUB_TOKEN=secret")
+			for _, unexpected := range tt.unexpected {
+				require.NotContains(t, env, unexpected)
+			}
+		})
+	}
+}
+
+func TestLoadEnvConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		contents      string
+		expected      EnvConfig
+		expectedError string
+	}{
+		{
+			name: "valid",
+			contents: `passEnv:
+  - AWS_PROFILE
+rules:
+  - pattern: clusters/*
+    env:
+      CLUSTER: dev
+`,
+			expected: EnvConfig{
+				PassEnv: []string{"AWS_PROFILE"},
+				Rules: []EnvRule{
+					{Pattern: "clusters/*", Env: map[string]string{"CLUSTER": "dev"}},
+				},
+			},
+		},
+		{
+			name:          "unknown field",
+			contents:      "rule: []\n",
+			expectedError: "error parsing environment config",
+		},
+		{
+			name:          "invalid pattern",
+			contents:      "rules:\n  - pattern: clusters/[\n",
+			expectedError: "invalid pattern 'clusters/[' in environment config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "env.yaml")
+			require.NoError(t, os.WriteFile(filename, []byte(tt.contents), 0o600))
+
+			config, err := LoadEnvConfig(filename)
+			if tt.expectedError != "" {
+				require.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, config)
+		})
+	}
+}
@@ -0,0 +1,239 @@
+// Package kustomizebuild finds the kustomizations in a repo containing a set
+// of files, e.g. those changed by a commit, and runs `kustomize build` on them
+package kustomizebuild
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Options configures how kustomizations are found and built
+type Options struct {
+	// RepoDir is the root of the repo, which paths are relative to
+	RepoDir string
+	// MinDepth is the minimum directory depth of files to find kustomizations
+	// for, files in shallower directories are ignored
+	MinDepth int
+	// RootMarker is the name of a file marking the directories that are
+	// deployable kustomization roots. When set, directories containing a
+	// 'kustomization.yaml' but no marker aren't built on their own
+	RootMarker string
+	// TruncateSecrets empties any strongbox encrypted files in the
+	// kustomizations before building them, so they can be built without
+	// decrypting them
+	TruncateSecrets bool
+	// WarningsAsErrors fails the build of any kustomization for which
+	// `kustomize build` reports a warning not matched by AllowedWarnings
+	WarningsAsErrors bool
+	AllowedWarnings  []*regexp.Regexp
+	// Env configures the environment `kustomize build` runs with
+	Env EnvConfig
+	// Limits bounds the manifests built from each kustomization
+	Limits Limits
+	// Logger logs progress, defaulting to slog.Default()
+	Logger *slog.Logger
+}
+
+func (o Options) logger() *slog.Logger {
+	if o.Logger == nil {
+		return slog.Default()
+	}
+	return o.Logger
+}
+
+// Result is the outcome of building the kustomizations containing a set of
+// files. Kustomization roots are relative to the repo root
+type Result struct {
+	// Manifests maps kustomization roots built to the manifests built
+	Manifests map[string]string
+	// Shallow lists the directories of files that were ignored for being
+	// shallower than the minimum depth
+	Shallow []string
+	// Components lists the kustomization roots that weren't built because
+	// they're components, which can't be built on their own
+	Components []string
+	// Failed maps kustomization roots that failed to build to the error
+	Failed map[string]error
+	// Warnings maps kustomization roots to the warnings `kustomize build`
+	// reported for them
+	Warnings map[string][]string
+}
+
+// Build builds the kustomizations containing the given files, which are
+// relative to the repo root. When building fails the partial result is
+// returned along with the error
+func Build(ctx context.Context, opts Options, paths []string) (*Result, error) {
+	result := &Result{
+		Manifests: map[string]string{},
+		Failed:    map[string]error{},
+		Warnings:  map[string][]string{},
+	}
+
+	if err := CheckKustomizeInstalled(); err != nil {
+		return result, err
+	}
+
+	result.Shallow = shallowDirs(paths, opts.MinDepth)
+	allRoots, err := FindRoots(opts, paths)
+	if err != nil {
+		return result, err
+	}
+
+	roots, components, err := FilterComponents(opts, allRoots)
+	if err != nil { //go-cov:skip
+		return result, err
+	}
+	result.Components = components
+
+	// truncate secrets so we can run `kustomize build` without having to decrypt them
+	if opts.TruncateSecrets {
+		if err := TruncateSecrets(ctx, opts, roots); err != nil {
+			return result, err
+		}
+	}
+
+	return result, buildManifests(ctx, opts, roots, result)
+}
+
+// CheckKustomizeInstalled checks that `kustomize` can be found on the PATH
+func CheckKustomizeInstalled() error {
+	if _, err := exec.LookPath("kustomize"); err != nil {
+		return errors.New(
+			"requires `kustomize` to be installed https://kubectl.docs.kubernetes.io/installation/kustomize/",
+		)
+	}
+	return nil
+}
+
+// buildManifests builds each of the kustomization roots, recording the outcome
+// in result
+func buildManifests(ctx context.Context, opts Options, roots []string, result *Result) error {
+	logger := opts.logger()
+	// `kustomize build` can take some time to run, particularly if it needs to
+	// fetch some remote resources, so call it concurrently
+	group := new(errgroup.Group)
+	mutex := new(sync.Mutex)
+	for i := range roots {
+		kustomizationRoot := roots[i]
+		logger.Debug("running `kustomize build`", "root", kustomizationRoot)
+		group.Go(func() error {
+			// fail records and logs why the root failed to build, so that
+			// failures can be attributed to the root as they happen
+			fail := func(err error) error {
+				logger.Error("build failed", "root", kustomizationRoot, "error", err.Error())
+				result.Failed[kustomizationRoot] = err
+				return err
+			}
+
+			manifest, stderr, err := kustomizeBuild(
+				ctx,
+				filepath.Join(opts.RepoDir, kustomizationRoot),
+				opts.Env.environ(kustomizationRoot),
+			)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				return fail(err)
+			}
+
+			warnings := WarningLines(stderr)
+			logWarnings(logger, kustomizationRoot, warnings)
+			if len(warnings) != 0 {
+				result.Warnings[kustomizationRoot] = warnings
+			}
+			if opts.WarningsAsErrors {
+				err := CheckWarnings(kustomizationRoot, warnings, opts.AllowedWarnings)
+				if err != nil {
+					return fail(err)
+				}
+			}
+
+			if err := opts.Limits.check(kustomizationRoot, manifest); err != nil {
+				return fail(err)
+			}
+
+			logger.Info("built", "root", kustomizationRoot)
+			result.Manifests[kustomizationRoot] = manifest
+			return nil
+		})
+	}
+	return group.Wait()
+}
+
+func kustomizeBuild(ctx context.Context, path string, env []string) (string, string, error) {
+	var stdout strings.Builder
+	var stderr strings.Builder
+	args := []string{"build", path}
+	cmd := exec.CommandContext(ctx, "kustomize", args...)
+	cmd.Env = env
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", "", fmt.Errorf(
+			"Error running 'kustomize %s': %v\nstderr: %s",
+			strings.Join(args, " "),
+			err,
+			stderr.String(),
+		)
+	}
+
+	return stdout.String(), stderr.String(), nil
+}
+
+// logWarnings logs each warning `kustomize build` reported for the given root
+// separately
+func logWarnings(logger *slog.Logger, kustomizationRoot string, warnings []string) {
+	for _, warning := range warnings {
+		logger.Warn("kustomize build warning", "root", kustomizationRoot, "warning", warning)
+	}
+}
+
+// CheckWarnings returns an error listing every warning `kustomize build`
+// reported for a kustomization root that isn't matched by one of the allowed
+// patterns
+func CheckWarnings(kustomizationRoot string, warnings []string, allowed []*regexp.Regexp) error {
+	var disallowed []string
+	for _, warning := range warnings {
+		isAllowed := false
+		for _, re := range allowed {
+			if re.MatchString(warning) {
+				isAllowed = true
+				break
+			}
+		}
+		if !isAllowed {
+			disallowed = append(disallowed, warning)
+		}
+	}
+
+	if len(disallowed) != 0 {
+		return fmt.Errorf(
+			"'kustomize build %s' reported warnings:\n\t%s",
+			kustomizationRoot,
+			strings.Join(disallowed, "\n\t"),
+		)
+	}
+	return nil
+}
+
+// WarningLines splits what `kustomize build` wrote to stderr into individual
+// warnings, one per non-empty line
+func WarningLines(stderr string) []string {
+	var warnings []string
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.TrimSpace(line) != "" {
+			warnings = append(warnings, line)
+		}
+	}
+	return warnings
+}
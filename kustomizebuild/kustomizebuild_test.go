@@ -0,0 +1,186 @@
+package kustomizebuild
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	simpleKustomization = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+
+resources:
+  - deployment.yaml
+`
+	componentKustomization = `apiVersion: kustomize.config.k8s.io/v1alpha1
+kind: Component
+`
+	simpleDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-cool-app
+`
+)
+
+func writeFiles(t *testing.T, dir string, fileContents map[string]string) {
+	t.Helper()
+	for path, contents := range fileContents {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(contents), 0o600))
+	}
+}
+
+func TestBuild(t *testing.T) {
+	repoDir := t.TempDir()
+	writeFiles(t, repoDir, map[string]string{
+		"README.md": "",
+		filepath.Join("app", "overlay", "kustomization.yaml"):   simpleKustomization,
+		filepath.Join("app", "overlay", "deployment.yaml"):      simpleDeployment,
+		filepath.Join("app", "component", "kustomization.yaml"): componentKustomization,
+	})
+
+	result, err := Build(
+		context.Background(),
+		Options{RepoDir: repoDir, MinDepth: 1},
+		[]string{
+			"README.md",
+			filepath.Join("app", "overlay", "deployment.yaml"),
+			filepath.Join("app", "component", "kustomization.yaml"),
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{filepath.Join("app", "overlay"): simpleDeployment}, result.Manifests)
+	require.Equal(t, []string{"."}, result.Shallow)
+	require.Equal(t, []string{filepath.Join("app", "component")}, result.Components)
+	require.Empty(t, result.Failed)
+}
+
+func TestBuildLogsFailuresWithRoot(t *testing.T) {
+	repoDir := t.TempDir()
+	writeFiles(t, repoDir, map[string]string{
+		filepath.Join("app", "overlay", "kustomization.yaml"): simpleKustomization,
+		filepath.Join("app", "overlay", "deployment.yaml"):    simpleDeployment,
+	})
+	var out strings.Builder
+
+	result, err := Build(
+		context.Background(),
+		Options{
+			RepoDir: repoDir,
+			Limits:  Limits{MaxBytes: 1},
+			Logger:  slog.New(slog.NewJSONHandler(&out, nil)),
+		},
+		[]string{filepath.Join("app", "overlay", "deployment.yaml")},
+	)
+	require.Error(t, err)
+	require.Contains(t, result.Failed, filepath.Join("app", "overlay"))
+
+	var errorRecords []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		if record["level"] == "ERROR" {
+			errorRecords = append(errorRecords, record)
+		}
+	}
+	require.Len(t, errorRecords, 1)
+	require.Equal(t, filepath.Join("app", "overlay"), errorRecords[0]["root"])
+	require.Equal(t, err.Error(), errorRecords[0]["error"])
+}
+
+func TestBuildFailsWhenCancelled(t *testing.T) {
+	repoDir := t.TempDir()
+	deploymentPath := filepath.Join("app", "deployment.yaml")
+	writeFiles(t, repoDir, map[string]string{
+		filepath.Join("app", "kustomization.yaml"): simpleKustomization,
+		deploymentPath: simpleDeployment,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := Build(ctx, Options{RepoDir: repoDir}, []string{deploymentPath})
+	require.ErrorContains(t, err, context.Canceled.Error())
+	require.Contains(t, result.Failed, "app")
+	require.Empty(t, result.Manifests)
+}
+
+func TestCheckWarnings(t *testing.T) {
+	basesWarning := "# Warning: 'bases' is deprecated. Please use 'resources' instead."
+	patchesWarning := "# Warning: 'patchesStrategicMerge' is deprecated. Please use 'patches' instead."
+	stderr := basesWarning + "\n\n" + patchesWarning + "\n"
+
+	tests := []struct {
+		name          string
+		stderr        string
+		allowed       []*regexp.Regexp
+		expectedError string
+	}{
+		{
+			name:   "no warnings",
+			stderr: "",
+		},
+		{
+			name:   "all warnings disallowed",
+			stderr: stderr,
+			expectedError: "'kustomize build manifests' reported warnings:\n\t" +
+				basesWarning + "\n\t" + patchesWarning,
+		},
+		{
+			name:          "some warnings allowed",
+			stderr:        stderr,
+			allowed:       []*regexp.Regexp{regexp.MustCompile("'bases' is deprecated")},
+			expectedError: "'kustomize build manifests' reported warnings:\n\t" + patchesWarning,
+		},
+		{
+			name:   "all warnings allowed",
+			stderr: stderr,
+			allowed: []*regexp.Regexp{
+				regexp.MustCompile("'bases'"),
+				regexp.MustCompile("'patchesStrategicMerge'"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckWarnings("manifests", WarningLines(tt.stderr), tt.allowed)
+			if tt.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.expectedError)
+			}
+		})
+	}
+}
+
+func TestLogWarningsTagsEachLineWithRoot(t *testing.T) {
+	var out strings.Builder
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+
+	logWarnings(
+		logger,
+		"manifests",
+		WarningLines("# Warning: 'bases' is deprecated\n\n# Warning: 'vars' is deprecated\n"),
+	)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	for i, expected := range []string{
+		"# Warning: 'bases' is deprecated",
+		"# Warning: 'vars' is deprecated",
+	} {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(lines[i]), &record))
+		require.Equal(t, "WARN", record["level"])
+		require.Equal(t, "manifests", record["root"])
+		require.Equal(t, expected, record["warning"])
+	}
+}
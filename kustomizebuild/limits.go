@@ -0,0 +1,105 @@
+package kustomizebuild
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Limits bounds the manifests built from each kustomization, so that e.g. a
+// generated ConfigMap too large for the API server fails the build rather than
+// the apply. Zero values are unlimited
+type Limits struct {
+	// MaxBytes is the maximum size of the manifests built from a
+	// kustomization
+	MaxBytes int64
+	// MaxObjects is the maximum number of objects built from a kustomization
+	MaxObjects int
+	// MaxConfigBytes is the maximum size of any ConfigMap or Secret, which
+	// the API server limits to around 1MiB
+	MaxConfigBytes int64
+}
+
+// check returns an error listing every limit the manifest exceeds
+func (l Limits) check(kustomizationRoot string, manifest string) error {
+	var exceeded []string
+	if l.MaxBytes > 0 && int64(len(manifest)) > l.MaxBytes {
+		exceeded = append(
+			exceeded,
+			fmt.Sprintf("manifests are %d bytes, more than %d", len(manifest), l.MaxBytes),
+		)
+	}
+
+	documents := SplitDocuments(manifest)
+	if l.MaxObjects > 0 && len(documents) > l.MaxObjects {
+		exceeded = append(
+			exceeded,
+			fmt.Sprintf("%d objects built, more than %d", len(documents), l.MaxObjects),
+		)
+	}
+
+	if l.MaxConfigBytes > 0 {
+		for _, document := range documents {
+			if int64(len(document)) <= l.MaxConfigBytes {
+				continue
+			}
+			var obj struct {
+				Kind     string `yaml:"kind"`
+				Metadata struct {
+					Name      string `yaml:"name"`
+					Namespace string `yaml:"namespace"`
+				} `yaml:"metadata"`
+			}
+			if err := yaml.Unmarshal([]byte(document), &obj); err != nil {
+				return fmt.Errorf(
+					"error parsing manifests built from %s: %v",
+					kustomizationRoot,
+					err,
+				)
+			}
+			if obj.Kind != "ConfigMap" && obj.Kind != "Secret" {
+				continue
+			}
+			name := obj.Metadata.Name
+			if obj.Metadata.Namespace != "" {
+				name = obj.Metadata.Namespace + "/" + name
+			}
+			exceeded = append(
+				exceeded,
+				fmt.Sprintf(
+					"%s %s is %d bytes, more than %d",
+					obj.Kind,
+					name,
+					len(document),
+					l.MaxConfigBytes,
+				),
+			)
+		}
+	}
+
+	if len(exceeded) != 0 {
+		return fmt.Errorf(
+			"manifests built from %s exceed limits:\n\t%s",
+			kustomizationRoot,
+			strings.Join(exceeded, "\n\t"),
+		)
+	}
+	return nil
+}
+
+// SplitDocuments splits a multi-document YAML manifest, as output by
+// `kustomize build`, into its documents. Each returned document ends with a
+// single newline
+func SplitDocuments(manifest string) []string {
+	var documents []string
+	// prepend a newline so a leading separator is handled like any other
+	for _, document := range strings.Split("\n"+manifest, "\n---\n") {
+		document = strings.TrimPrefix(document, "\n")
+		if strings.TrimSpace(document) == "" {
+			continue
+		}
+		documents = append(documents, strings.TrimSuffix(document, "\n")+"\n")
+	}
+	return documents
+}
@@ -0,0 +1,65 @@
+package kustomizebuild
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitsCheck(t *testing.T) {
+	configMap := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: big-config
+  namespace: my-namespace
+data:
+  key: ` + strings.Repeat("a", 100) + "\n"
+	deployment := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: ` + strings.Repeat("a", 100) + "\n"
+	manifest := configMap + "---\n" + deployment
+
+	tests := []struct {
+		name          string
+		limits        Limits
+		expectedError string
+	}{
+		{
+			name:   "unlimited",
+			limits: Limits{},
+		},
+		{
+			name: "within limits",
+			limits: Limits{
+				MaxBytes:       int64(len(manifest)),
+				MaxObjects:     2,
+				MaxConfigBytes: int64(len(configMap)),
+			},
+		},
+		{
+			name: "exceeds every limit",
+			limits: Limits{
+				MaxBytes:       100,
+				MaxObjects:     1,
+				MaxConfigBytes: 100,
+			},
+			expectedError: "manifests built from manifests exceed limits:\n\t" +
+				"manifests are 360 bytes, more than 100\n\t" +
+				"2 objects built, more than 1\n\t" +
+				"ConfigMap my-namespace/big-config is 200 bytes, more than 100",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.check("manifests", manifest)
+			if tt.expectedError == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.expectedError)
+			}
+		})
+	}
+}
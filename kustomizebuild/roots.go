@@ -0,0 +1,223 @@
+package kustomizebuild
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Kustomization represents the structure of a Kustomization file
+type Kustomization struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+}
+
+// FindRoots finds, for each given path, the first parent directory containing
+// a 'kustomization.yaml', and the root marker file if set. Paths are followed
+// through any symlinks to directories in the repo, so a file is attributed to
+// every kustomization that sees it. It returns a list of such paths relative
+// to the repo root, and fails if any resolves to outside the repo
+func FindRoots(opts Options, paths []string) ([]string, error) {
+	links, err := findDirSymlinks(opts.RepoDir)
+	if err != nil {
+		return nil, err
+	}
+	paths = resolveSymlinks(links, paths)
+
+	// Group paths by shared prefixes and return their deepest common directories
+	paths = deepestCommonDirs(paths, opts.MinDepth)
+
+	// there may be multiple changes under the same path
+	// so use a map to track unique ones
+	rootsMap := map[string]struct{}{}
+	for _, path := range paths {
+		kustomizationRoot, err := findKustomizationRoot(opts.RepoDir, path, opts.RootMarker)
+		if err != nil {
+			return nil, err
+		}
+		if kustomizationRoot == "" {
+			continue
+		}
+
+		if _, exists := rootsMap[kustomizationRoot]; !exists {
+			if err := checkWithinRepo(opts.RepoDir, kustomizationRoot); err != nil {
+				return nil, err
+			}
+			opts.logger().Info("found kustomization build dir", "root", kustomizationRoot)
+			rootsMap[kustomizationRoot] = struct{}{}
+		}
+	}
+
+	roots := make([]string, 0, len(rootsMap))
+	for root := range rootsMap {
+		roots = append(roots, root)
+	}
+	return roots, nil
+}
+
+func findKustomizationRoot(
+	repoRoot string,
+	relativePath string,
+	rootMarker string,
+) (string, error) {
+	markers := []string{"kustomization.yaml"}
+	if rootMarker != "" {
+		markers = append(markers, rootMarker)
+	}
+
+	for dir := filepath.Dir(relativePath); dir != ".."; dir = filepath.Clean(filepath.Join(dir, "..")) {
+		found, err := containsFiles(filepath.Join(repoRoot, dir), markers)
+		if err != nil {
+			return "", fmt.Errorf("error checking for file in %s: %v", dir, err)
+		}
+		if found {
+			return dir, nil
+		}
+		// continue up the directory tree
+	}
+	return "", nil
+}
+
+// containsFiles checks whether dir contains every one of the named files
+func containsFiles(dir string, names []string) (bool, error) {
+	for _, name := range names {
+		_, err := os.Stat(filepath.Join(dir, name))
+		switch {
+		case os.IsNotExist(err):
+			return false, nil
+		case err != nil:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// deepestCommonDirs takes a list of file paths and returns a list of directory paths
+// that represent the deepest common directory for each group of similarly prefixed files
+// with a minimum directory depth enforced.
+// Example (with minDepth = 2):
+// Input: ["aaa/file.yaml", "aaa/bbb/file.yaml", "bbb/ccc/file1.yaml", "bbb/ccc/file2.yaml"]
+// Output: ["aaa/bbb/", "bbb/ccc/"]
+func deepestCommonDirs(paths []string, minDepth int) []string {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	dirSet := make(map[string]struct{})
+
+	for _, path := range paths {
+		dir := filepath.ToSlash(filepath.Dir(path))
+		if dir == "." {
+			// File in root directory is represented as empty string
+			dir = ""
+		}
+		dirSet[dir] = struct{}{}
+	}
+
+	// Filter out directories that are shallower than minDepth
+	var dirs []string
+	for dir := range dirSet {
+		segments := strings.Split(dir, "/")
+		if dir == "" {
+			segments = []string{}
+		}
+		if len(segments) >= minDepth {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs) // Sort for consistent and hierarchical comparison
+
+	var result []string
+	skipPrefixes := make(map[string]struct{})
+
+	for _, dir := range dirs {
+		skip := false
+
+		for parent := range skipPrefixes {
+			if strings.HasPrefix(dir+"/", parent+"/") {
+				skip = true
+				break
+			}
+		}
+
+		if !skip {
+			skipPrefixes[dir] = struct{}{}
+			if dir == "" {
+				result = append(result, "")
+			} else {
+				result = append(result, dir+"/")
+			}
+		}
+	}
+
+	return result
+}
+
+// shallowDirs returns the directories of the given file paths that are
+// shallower than minDepth, and so are left out by deepestCommonDirs
+func shallowDirs(paths []string, minDepth int) []string {
+	dirSet := map[string]struct{}{}
+	for _, path := range paths {
+		dir := filepath.ToSlash(filepath.Dir(path))
+		depth := 0
+		if dir != "." {
+			depth = len(strings.Split(dir, "/"))
+		}
+		if depth < minDepth {
+			dirSet[dir] = struct{}{}
+		}
+	}
+	return slices.Sorted(maps.Keys(dirSet))
+}
+
+// FilterComponents splits the kustomization roots into those that can be
+// built and those with kind: Component.
+// We can't expect standalone Component kustomization files to correctly render.
+func FilterComponents(opts Options, roots []string) ([]string, []string, error) {
+	buildable := []string{}
+	var components []string
+	for _, root := range roots {
+		isComponent, err := IsComponent(filepath.Join(opts.RepoDir, root, "kustomization.yaml"))
+		if err != nil { //go-cov:skip
+			return nil, nil, err
+		}
+		if isComponent {
+			opts.logger().Debug("skipping component kustomization", "root", root)
+			components = append(components, root)
+			continue
+		}
+		buildable = append(buildable, root)
+	}
+	return buildable, components, nil
+}
+
+// IsComponent checks whether the kustomization file at filepath has
+// kind: Component
+func IsComponent(filepath string) (bool, error) {
+	file, err := os.Open(filepath)
+	if err != nil { //go-cov:skip
+		return false, fmt.Errorf("failed opening kustomization file: %s: %v", filepath, err)
+	}
+	defer file.Close()
+
+	// Read the file's content
+	data, err := io.ReadAll(file)
+	if err != nil { //go-cov:skip
+		return false, fmt.Errorf("error reading file: %v", err)
+	}
+
+	// Unmarshal the YAML into the struct
+	var kustomization Kustomization
+	err = yaml.Unmarshal(data, &kustomization)
+	if err != nil { //go-cov:skip
+		return false, fmt.Errorf("error unmarshaling YAML: %v", err)
+	}
+	return kustomization.Kind == "Component", nil
+}
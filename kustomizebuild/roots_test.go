@@ -0,0 +1,143 @@
+package kustomizebuild
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeepestCommonDirs(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []string
+		minDepth int
+		expected []string
+	}{
+		{
+			name:     "empty input",
+			input:    []string{},
+			minDepth: 0,
+			expected: nil,
+		},
+		{
+			name:     "single path, depth 0",
+			input:    []string{"aaa/bbb/ccc/file.yaml"},
+			minDepth: 0,
+			expected: []string{"aaa/bbb/ccc/"},
+		},
+		{
+			name: "multiple grouped paths",
+			input: []string{
+				"file.yaml",
+				"aaa/bbb/ccc/file.yaml",
+				"aaa/bbb/ccc/bbb/file.yaml",
+				"aaa/bbb/ccc/ddd/file1.yaml",
+				"aaa/bbb/ccc/ddd/file2.yaml",
+				"aaa/bbb/ccc/ddd/eee/file.yaml",
+				"bbb/ccc/file.yaml",
+				"bbb/ccc/ddd/file.yaml",
+				"ccc/ddd/eee/fff/ggg/hhh/file.yaml",
+			},
+			minDepth: 1,
+			expected: []string{
+				"aaa/bbb/ccc/",
+				"bbb/ccc/",
+				"ccc/ddd/eee/fff/ggg/hhh/",
+			},
+		},
+		{
+			name: "no shared prefixes",
+			input: []string{
+				"aaa/file.yaml",
+				"bbb/file.yaml",
+				"ccc/file.yaml",
+			},
+			minDepth: 1,
+			expected: []string{
+				"aaa/",
+				"bbb/",
+				"ccc/",
+			},
+		},
+		{
+			name: "nested and flat mix",
+			input: []string{
+				"aaa/bbb/ccc/file.yaml",
+				"aaa/bbb/file.yaml",
+				"bbb/ccc/file.yaml",
+			},
+			minDepth: 1,
+			expected: []string{
+				"aaa/bbb/",
+				"bbb/ccc/",
+			},
+		},
+		{
+			name:     "single file in root dir",
+			input:    []string{"file.yaml"},
+			minDepth: 0,
+			expected: []string{""},
+		},
+		{
+			name: "multiple files, depth 0",
+			input: []string{
+				"file.yaml",
+				"aaa/bbb/file.yaml",
+				"ccc/file.yaml",
+			},
+			minDepth: 0,
+			expected: []string{
+				"",
+				"aaa/bbb/",
+				"ccc/",
+			},
+		},
+		{
+			name: "multiple files, depth 1",
+			input: []string{
+				"file.yaml",
+				"aaa/bbb/file.yaml",
+				"ccc/file.yaml",
+			},
+			minDepth: 1,
+			expected: []string{
+				"aaa/bbb/",
+				"ccc/",
+			},
+		},
+		{
+			name: "multiple files, depth 2",
+			input: []string{
+				"file.yaml",
+				"aaa/file.yaml",
+				"aaa/bbb/file.yaml",
+				"bbb/ccc/file.yaml",
+			},
+			minDepth: 2,
+			expected: []string{
+				"aaa/bbb/",
+				"bbb/ccc/",
+			},
+		},
+		{
+			name: "multiple files, depth 2",
+			input: []string{
+				"file.yaml",
+				"aaa/bbb/file.yaml",
+				"aaa/bbb/ccc/file.yaml",
+				"aaa/bbb/ccc/ddd/file.yaml",
+			},
+			minDepth: 3,
+			expected: []string{
+				"aaa/bbb/ccc/",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := deepestCommonDirs(tt.input, tt.minDepth)
+			require.Equal(t, tt.expected, got)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package kustomizebuild
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// TruncateSecrets empties every strongbox encrypted file under the given
+// directories of the repo
+func TruncateSecrets(ctx context.Context, opts Options, dirs []string) error {
+	secrets, err := FindSecrets(ctx, opts.RepoDir, dirs)
+	if err != nil {
+		return err
+	}
+
+	for _, secret := range secrets {
+		opts.logger().Debug("truncating secret", "path", secret)
+		if err := truncateFile(filepath.Join(opts.RepoDir, secret)); err != nil {
+			return fmt.Errorf("error truncating secrets file '%s': %v", secret, err)
+		}
+	}
+
+	return nil
+}
+
+// truncateFile empties the file at path, closing it before returning
+func truncateFile(path string) error {
+	file, err := os.OpenFile(path, os.O_TRUNC, 0o002)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// FindSecrets finds files under rootDir, that is assumed to be within a git
+// repo, that appear to be strongbox encoded secrets
+func FindSecrets(ctx context.Context, rootDir string, dirs []string) ([]string, error) {
+	// files that look to be strongbox encrypted based on their git attributes
+	// docs https://git-scm.com/docs/gitglossary#Documentation/gitglossary.txt-aiddefpathspecapathspec
+	encryptedPathspec := ":(attr:filter=strongbox diff=strongbox)"
+	pathspecs := make([]string, len(dirs))
+	for i, dir := range dirs {
+		pathspecs[i] = encryptedPathspec + dir
+	}
+
+	var stdout strings.Builder
+	var stderr strings.Builder
+	// "-z" to use null byte as field terminator, in case someone creates a
+	// file with a "\n" in the name (for some reason)
+	// we prepend the pathspec 'not/a/path' that will match nothing to:
+	//	1) Avoid matching everything when dirs is empty
+	//	2) To work around a bug in 'ls-files': https://lore.kernel.org/git/CAEzX-aD1wfgp8AvNNfCXVM3jAaAjK+uFTqS2XP4CJbVvFr2BtQ@mail.gmail.com/
+	args := append([]string{"-C", rootDir, "ls-files", "-z", "--", "not/a/path"}, pathspecs...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf(
+			"Error listing secrets via 'git %s': %v\nstderr: %s",
+			strings.Join(args, " "),
+			err,
+			stderr.String(),
+		)
+	}
+
+	secrets := strings.Split(stdout.String(), "\x00")
+	// there's always a trailing '\x00' so trim that element
+	return secrets[:len(secrets)-1], nil
+}
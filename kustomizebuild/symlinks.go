@@ -0,0 +1,147 @@
+package kustomizebuild
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dirSymlink is a symlink to a directory within the repo, both paths being
+// relative to the repo root
+type dirSymlink struct {
+	path   string
+	target string
+}
+
+// findDirSymlinks finds every symlink under repoDir, other than in hidden
+// directories, that resolves to a directory within the repo. Links to one of
+// their own parents are ignored, since following them would never end
+func findDirSymlinks(repoDir string) ([]dirSymlink, error) {
+	realRepoDir, err := filepath.EvalSymlinks(repoDir)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving repo directory: %v", err)
+	}
+
+	var links []dirSymlink
+	walkFunc := func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// unreadable directories are reported when searching them for
+			// kustomizations, if they're relevant
+			return nil
+		}
+		if entry.IsDir() {
+			// skip '.git' and the like
+			if path != repoDir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			// dangling links can't affect a build
+			return nil
+		}
+		if info, err := os.Stat(target); err != nil || !info.IsDir() {
+			return nil
+		}
+		relTarget, err := filepath.Rel(realRepoDir, target)
+		if err != nil || !filepath.IsLocal(relTarget) {
+			return nil
+		}
+		relPath, err := filepath.Rel(repoDir, path)
+		if err != nil { //go-cov:skip
+			return nil
+		}
+		if IsWithinDir(relPath, relTarget) {
+			return nil
+		}
+		links = append(links, dirSymlink{path: relPath, target: relTarget})
+		return nil
+	}
+	if err := filepath.WalkDir(repoDir, walkFunc); err != nil { //go-cov:skip
+		return nil, fmt.Errorf("error searching for symlinks: %v", err)
+	}
+	return links, nil
+}
+
+// resolveSymlinks returns the given paths along with every other path through
+// which they can be reached via the links, so that a change to a file is
+// attributed to every kustomization that sees it. A path never goes through
+// the same link twice, since links to each other, e.g. 'a/l -> ../b' and
+// 'b/l -> ../a', would otherwise give endlessly longer paths
+func resolveSymlinks(links []dirSymlink, paths []string) []string {
+	// linkedPath is a path along with the links it goes through
+	type linkedPath struct {
+		path string
+		via  map[int]bool
+	}
+
+	seen := map[string]struct{}{}
+	var resolved []string
+	var queue []linkedPath
+	for _, path := range paths {
+		queue = append(queue, linkedPath{path: path})
+	}
+	for len(queue) != 0 {
+		path, via := filepath.Clean(queue[0].path), queue[0].via
+		queue = queue[1:]
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		seen[path] = struct{}{}
+		resolved = append(resolved, path)
+
+		for i, link := range links {
+			// the file as seen through the link
+			if IsWithinDir(path, link.target) && !via[i] {
+				rel, _ := filepath.Rel(link.target, path)
+				linkVia := map[int]bool{i: true}
+				for j := range via {
+					linkVia[j] = true
+				}
+				queue = append(queue, linkedPath{path: filepath.Join(link.path, rel), via: linkVia})
+			}
+			// the file the link points to
+			if IsWithinDir(path, link.path) {
+				rel, _ := filepath.Rel(link.path, path)
+				queue = append(queue, linkedPath{path: filepath.Join(link.target, rel), via: via})
+			}
+		}
+	}
+	return resolved
+}
+
+// checkWithinRepo returns an error if the kustomization root resolves to a
+// directory outside the repo, e.g. through a symlink
+func checkWithinRepo(repoDir string, root string) error {
+	realRepoDir, err := filepath.EvalSymlinks(repoDir)
+	if err != nil {
+		return fmt.Errorf("error resolving repo directory: %v", err)
+	}
+	realRoot, err := filepath.EvalSymlinks(filepath.Join(repoDir, root))
+	if err != nil {
+		return fmt.Errorf("error resolving kustomization root %s: %v", root, err)
+	}
+	if rel, err := filepath.Rel(realRepoDir, realRoot); err != nil || !filepath.IsLocal(rel) {
+		return fmt.Errorf(
+			"kustomization root %s resolves to %s, outside the repo",
+			root,
+			realRoot,
+		)
+	}
+	return nil
+}
+
+// IsWithinDir checks whether path is dir or within it. The paths are compared
+// lexically once cleaned, without resolving symlinks, so they must both be
+// absolute or both be relative to the same directory
+func IsWithinDir(path string, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && filepath.IsLocal(rel)
+}
@@ -0,0 +1,146 @@
+package kustomizebuild
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindRootsThroughSymlinks(t *testing.T) {
+	repoDir := t.TempDir()
+	clusterKustomization := `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+
+resources:
+  - app/deployment.yaml
+`
+	writeFiles(t, repoDir, map[string]string{
+		filepath.Join("shared", "app", "deployment.yaml"): simpleDeployment,
+		filepath.Join("cluster-a", "kustomization.yaml"):  clusterKustomization,
+		filepath.Join("cluster-b", "kustomization.yaml"):  clusterKustomization,
+	})
+	for _, cluster := range []string{"cluster-a", "cluster-b"} {
+		require.NoError(
+			t,
+			os.Symlink(
+				filepath.Join("..", "shared", "app"),
+				filepath.Join(repoDir, cluster, "app"),
+			),
+		)
+	}
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{
+			name: "changed link target",
+			path: filepath.Join("shared", "app", "deployment.yaml"),
+		},
+		{
+			name: "changed through link",
+			path: filepath.Join("cluster-a", "app", "deployment.yaml"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roots, err := FindRoots(Options{RepoDir: repoDir}, []string{tt.path})
+			require.NoError(t, err)
+			require.ElementsMatch(t, []string{"cluster-a", "cluster-b"}, roots)
+		})
+	}
+}
+
+func TestFindRootsFailsWhenRootOutsideRepo(t *testing.T) {
+	repoDir := t.TempDir()
+	outsideDir := t.TempDir()
+	writeFiles(t, outsideDir, map[string]string{
+		"kustomization.yaml": simpleKustomization,
+		"deployment.yaml":    simpleDeployment,
+	})
+	require.NoError(t, os.Symlink(outsideDir, filepath.Join(repoDir, "app")))
+	realOutsideDir, err := filepath.EvalSymlinks(outsideDir)
+	require.NoError(t, err)
+
+	_, err = FindRoots(
+		Options{RepoDir: repoDir},
+		[]string{filepath.Join("app", "deployment.yaml")},
+	)
+	require.EqualError(
+		t,
+		err,
+		"kustomization root app resolves to "+realOutsideDir+", outside the repo",
+	)
+}
+
+func TestResolveSymlinks(t *testing.T) {
+	links := []dirSymlink{
+		{path: "cluster-a/app", target: "shared/app"},
+		{path: "shared/app/base", target: "base"},
+	}
+
+	require.ElementsMatch(
+		t,
+		[]string{
+			"base/deployment.yaml",
+			"shared/app/base/deployment.yaml",
+			"cluster-a/app/base/deployment.yaml",
+		},
+		resolveSymlinks(links, []string{"base/deployment.yaml"}),
+	)
+	require.Equal(
+		t,
+		[]string{"other/deployment.yaml"},
+		resolveSymlinks(links, []string{"other/deployment.yaml"}),
+	)
+}
+
+func TestResolveSymlinksCycle(t *testing.T) {
+	links := []dirSymlink{
+		{path: "a/l", target: "b"},
+		{path: "b/l", target: "a"},
+	}
+
+	require.ElementsMatch(
+		t,
+		[]string{
+			"a/deployment.yaml",
+			"b/l/deployment.yaml",
+			"a/l/l/deployment.yaml",
+		},
+		resolveSymlinks(links, []string{"a/deployment.yaml"}),
+	)
+}
+
+func TestFindRootsThroughSymlinkCycle(t *testing.T) {
+	repoDir := t.TempDir()
+	writeFiles(t, repoDir, map[string]string{
+		filepath.Join("a", "kustomization.yaml"): simpleKustomization,
+		filepath.Join("a", "deployment.yaml"):    simpleDeployment,
+		filepath.Join("b", "README.md"):          "",
+	})
+	require.NoError(t, os.Symlink(filepath.Join("..", "b"), filepath.Join(repoDir, "a", "l")))
+	require.NoError(t, os.Symlink(filepath.Join("..", "a"), filepath.Join(repoDir, "b", "l")))
+
+	roots, err := FindRoots(
+		Options{RepoDir: repoDir},
+		[]string{filepath.Join("a", "deployment.yaml")},
+	)
+	require.NoError(t, err)
+	require.Contains(t, roots, "a")
+}
+
+func TestIsWithinDir(t *testing.T) {
+	for path, expected := range map[string]bool{
+		"/repo/out":            true,
+		"/repo/out/manifests":  true,
+		"/repo/outdir":         false,
+		"/repo/manifests":      false,
+		"/other/out/manifests": false,
+	} {
+		require.Equal(t, expected, IsWithinDir(path, "/repo/out"), path)
+	}
+}